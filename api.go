@@ -7,52 +7,120 @@ import (
 	"net/http"
 	"sort"
 	"strconv"
-	"strings"
+	"time"
 )
 
 type parameters struct {
 	// these tags indicate how the keys in the JSON should be mapped to the struct fields
 	// the struct fields must be exported (start with a capital letter) if you want them parsed
-	Body string `json:"body"`
+	Body           string `json:"body"`
+	ExpiresIn      int    `json:"expires_in,omitempty"`
+	Visibility     string `json:"visibility,omitempty"`
+	ContentWarning string `json:"content_warning,omitempty"`
+	Sensitive      bool   `json:"sensitive,omitempty"`
 }
 
 type Chirp struct {
-	Body     string `json:"body"`
-	ID       int    `json:"id"`
-	AuthorID int    `json:"author_id"`
+	Body           string     `json:"body"`
+	ID             int        `json:"id"`
+	AuthorID       int        `json:"author_id"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	Visibility     string     `json:"visibility,omitempty"`
+	ContentWarning string     `json:"content_warning,omitempty"`
+	Sensitive      bool       `json:"sensitive,omitempty"`
+	// Collapsed is only set in responses when the body was hidden behind the content warning
+	Collapsed bool `json:"collapsed,omitempty"`
+	// Hidden chirps were removed by a moderator and are only visible to their author
+	Hidden bool `json:"hidden,omitempty"`
+	// HeldForReview chirps are only visible to their author until a moderator approves them
+	HeldForReview bool      `json:"held_for_review,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+const maxContentWarningLength = 100
+
+// maxExpiresIn is the longest lifetime in seconds of an ephemeral chirp or
+// a keyword filter, one year. It keeps the expiry from overflowing time.Duration.
+const maxExpiresIn = 365 * 24 * 60 * 60
+
+// Expired reports whether an ephemeral chirp has passed its expiry time.
+// Chirps without an expiry never expire.
+func (c Chirp) Expired(now time.Time) bool {
+	return c.ExpiresAt != nil && !c.ExpiresAt.After(now)
+}
+
+// Collapse hides the body of a chirp with a content warning or sensitive
+// media, so only the warning is shown until the reader opens the chirp
+func (c Chirp) Collapse() Chirp {
+	if c.ContentWarning == "" && !c.Sensitive {
+		return c
+	}
+	c.Body = ""
+	c.Collapsed = true
+	return c
+}
+
+// showChirp prepares a chirp for a listing, chirps with a content warning
+// are collapsed unless the viewer opted in or wrote them
+func (dbs DBStructure) showChirp(viewerid int, chirp Chirp) Chirp {
+	if dbs.Users[viewerid].ShowSensitive || chirp.AuthorID == viewerid {
+		return chirp
+	}
+	return chirp.Collapse()
 }
 
 func (cfg *apiConfig) GetChirpID(w http.ResponseWriter, req *http.Request) {
+	viewerid, err := cfg.OptionalViewer(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
 	db, err := cfg.DB.loadDB()
 	if err != nil {
 		respondWithError(w, 500, "cannot load db")
+		return
 	}
 	id := req.PathValue("chirpID")
 	iid, err := strconv.Atoi(id)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "id could not be parsed")
+		return
 	}
 
 	Chirp, exists := db.Chirps[iid]
-	// If the key exists
-	if !exists {
+	// If the key exists and the caller may see the chirp
+	if !exists || !db.CanView(viewerid, Chirp, time.Now().UTC()) {
 		respondWithError(w, 404, "404 page not found")
+		return
 	}
 
 	respondWithJSON(w, 200, Chirp)
 }
 
 func (cfg *apiConfig) GetChirps(w http.ResponseWriter, req *http.Request) {
+	viewerid, err := cfg.OptionalViewer(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
 	db, err := cfg.DB.loadDB()
 	if err != nil {
 		respondWithError(w, 500, "cannot load db")
+		return
 	}
+	now := time.Now().UTC()
 	/* Update the GET /api/chirps endpoint. It should accept an optional query parameter called sort. It can have 2 possible values:
 
 	asc - Sort the chirps in the response by id in ascending order
 	desc - Sort the chirps in the response by id in descending order
 	asc is the default if no sort query parameter is provided.
 	*/
+	// muted and blocked authors and personal keyword filters are left out
+	// of every listing, they never hide the callers own chirps
+	filtered := keywordMatcher(db.Users[viewerid].KeywordFilters, now)
+	hidden := func(chirp Chirp) bool {
+		return chirp.AuthorID != viewerid && (db.IsMuted(viewerid, chirp.AuthorID) || filtered(chirp))
+	}
 	ssort := req.URL.Query().Get("sort")
 	if ssort != "asc" && ssort != "desc" {
 		ssort = "asc"
@@ -64,21 +132,28 @@ func (cfg *apiConfig) GetChirps(w http.ResponseWriter, req *http.Request) {
 		author_id_int, err := strconv.Atoi(author_id)
 		if err != nil {
 			respondWithError(w, 400, "author_id could not be parsed")
+			return
 		}
 		Chirps := []Chirp{}
 
 		for _, chirp := range db.Chirps {
-			if chirp.AuthorID == author_id_int {
-				Chirps = append(Chirps, chirp)
+			if chirp.AuthorID == author_id_int && db.CanView(viewerid, chirp, now) && !hidden(chirp) {
+				Chirps = append(Chirps, db.showChirp(viewerid, chirp))
 			}
 		}
 		Chirps = SortingChirps(Chirps, ssort)
+		// the pinned chirp of the author is always returned first
+		Chirps = PinnedFirst(Chirps, db.Users[author_id_int].PinnedChirpID)
 		respondWithJSON(w, 200, Chirps)
+		return
 	}
 
 	Chirps := []Chirp{}
 	for _, chirp := range db.Chirps {
-		Chirps = append(Chirps, chirp)
+		if !db.CanView(viewerid, chirp, now) || hidden(chirp) {
+			continue
+		}
+		Chirps = append(Chirps, db.showChirp(viewerid, chirp))
 	}
 	Chirps = SortingChirps(Chirps, ssort)
 	respondWithJSON(w, 200, Chirps)
@@ -107,14 +182,16 @@ func (cfg *apiConfig) DelChirpID(w http.ResponseWriter, req *http.Request) {
 	db, err := cfg.DB.loadDB()
 	if err != nil {
 		respondWithError(w, 500, "cannot load db")
+		return
 	}
 	sid := req.PathValue("chirpID")
 	chirpid, err := strconv.Atoi(sid)
 	if err != nil {
 		respondWithError(w, 400, "Chirp id could not be parsed")
+		return
 	}
 	chirp, exists := db.Chirps[chirpid]
-	if !exists {
+	if !exists || !db.CanView(userid, chirp, time.Now().UTC()) {
 		respondWithError(w, 404, "Chirp does not exist")
 		return
 	}
@@ -129,7 +206,7 @@ func (cfg *apiConfig) DelChirpID(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	delete(db.Chirps, chirpid)
+	db.deleteChirp(chirpid)
 	cfg.DB.writeDB(db)
 	respondWithJSON(w, 200, "Chirp deleted")
 }
@@ -140,7 +217,9 @@ func (cfg *apiConfig) PostChirps(w http.ResponseWriter, req *http.Request) {
 		respondWithError(w, 401, "Unauthorized")
 		return
 	}
-
+	if !cfg.requireVerified(w, userid, RestrictChirps) {
+		return
+	}
 	decoder := json.NewDecoder(req.Body)
 	params := parameters{}
 	err = decoder.Decode(&params)
@@ -154,33 +233,65 @@ func (cfg *apiConfig) PostChirps(w http.ResponseWriter, req *http.Request) {
 		respondWithError(w, 400, msg)
 		return
 	}
-	cleaned_body := replaceProfane(params.Body)
-
-	validChirp, err := cfg.DB.CreateChirp(cleaned_body, userid)
+	if params.ExpiresIn < 0 {
+		respondWithError(w, 400, "expires_in must not be negative")
+		return
+	}
+	if params.ExpiresIn > maxExpiresIn {
+		respondWithError(w, 400, fmt.Sprintf("expires_in must not be more than %d seconds", maxExpiresIn))
+		return
+	}
+	if len(params.ContentWarning) > maxContentWarningLength {
+		respondWithError(w, 400, "Content warning is too long")
+		return
+	}
+	visibility, err := validVisibility(params.Visibility)
 	if err != nil {
-		fmt.Printf("error: %s", err.Error())
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	cleaned_body, matches := cfg.Profanity.Check(params.Body)
+	if rejected(matches) {
+		cfg.recordModeration(moderationEvents(0, userid, matches))
+		respondWithError(w, 400, "Chirp violates the content policy")
+		return
 	}
 
-	respondWithJSON(w, 201, validChirp)
-}
+	newChirp := Chirp{
+		Body:           cleaned_body,
+		AuthorID:       userid,
+		Visibility:     visibility,
+		ContentWarning: params.ContentWarning,
+		Sensitive:      params.Sensitive,
+	}
+	if params.ExpiresIn > 0 {
+		expiresAt := time.Now().UTC().Add(time.Duration(params.ExpiresIn) * time.Second)
+		newChirp.ExpiresAt = &expiresAt
+	}
 
-func replaceProfane(body string) (cleaned_body string) {
-	badWords := map[string]struct{}{
-		"kerfuffle": {},
-		"sharbert":  {},
-		"fornax":    {},
+	db, err := cfg.DB.loadDB()
+	if err != nil {
+		respondWithError(w, 500, "cannot load db")
+		return
 	}
-	words := strings.Split(body, " ")
+	spam := cfg.Spam.Evaluate(db, newChirp, time.Now().UTC())
+	if spam.Decision == SpamReject {
+		cfg.recordModeration(spam.moderationEvents(0, userid))
+		respondWithError(w, 400, "Chirp looks like spam")
+		return
+	}
+	newChirp.HeldForReview = spam.Decision == SpamReview
 
-	for i, word := range words {
-		loweredWord := strings.ToLower(word)
-		if _, ok := badWords[loweredWord]; ok {
-			words[i] = "****"
-		}
+	validChirp, err := cfg.DB.CreateChirp(newChirp)
+	if err != nil {
+		fmt.Printf("error: %s", err.Error())
+		respondWithError(w, 500, "cannot create chirp")
+		return
 	}
+	cfg.recordModeration(moderationEvents(validChirp.ID, userid, matches))
+	cfg.recordModeration(spam.moderationEvents(validChirp.ID, userid))
 
-	cleaned_body = strings.Join(words, " ")
-	return cleaned_body
+	respondWithJSON(w, 201, validChirp)
 }
 
 func respondWithError(w http.ResponseWriter, code int, msg string) {
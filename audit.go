@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Types of actors in the audit trail
+const (
+	ActorUser    = "user"
+	ActorSystem  = "system"
+	ActorWebhook = "webhook"
+)
+
+// Actor identifies who took a privileged action and in which request
+type Actor struct {
+	ID        int
+	Type      string
+	RequestID string
+}
+
+// AuditEvent records an action taken by a moderator, admin or an external
+// system. Events are only ever appended, never changed or removed.
+type AuditEvent struct {
+	ID int `json:"id"`
+	// ActorID is the user who took the action, 0 for other actor types
+	ActorID    int    `json:"actor_id"`
+	ActorType  string `json:"actor_type"`
+	Action     string `json:"action"`
+	TargetType string `json:"target_type"`
+	TargetID   int    `json:"target_id"`
+	Details    string `json:"details,omitempty"`
+	// Before and After hold the audited state of the target
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// auditUser is the part of a user recorded in the audit trail,
+// credentials never end up in there
+type auditUser struct {
+	Role           string     `json:"role"`
+	IsChirpyRed    bool       `json:"is_chirpy_red"`
+	Suspended      bool       `json:"suspended"`
+	SuspendedUntil *time.Time `json:"suspended_until,omitempty"`
+	HideChirps     bool       `json:"hide_chirps"`
+	Shadowbanned   bool       `json:"shadowbanned"`
+}
+
+func auditUserState(u User) json.RawMessage {
+	return auditState(auditUser{
+		Role:           u.RoleOrDefault(),
+		IsChirpyRed:    u.IsChirpyRed,
+		Suspended:      u.Suspended,
+		SuspendedUntil: u.SuspendedUntil,
+		HideChirps:     u.HideChirps,
+		Shadowbanned:   u.Shadowbanned,
+	})
+}
+
+// auditChirp is the part of a chirp recorded in the audit trail
+type auditChirp struct {
+	Hidden        bool `json:"hidden"`
+	HeldForReview bool `json:"held_for_review"`
+}
+
+func auditChirpState(c Chirp) json.RawMessage {
+	return auditState(auditChirp{
+		Hidden:        c.Hidden,
+		HeldForReview: c.HeldForReview,
+	})
+}
+
+func auditState(state interface{}) json.RawMessage {
+	dat, err := json.Marshal(state)
+	if err != nil {
+		return nil
+	}
+	return dat
+}
+
+// appendAudit adds an event to the audit trail, it is written together
+// with the change it describes
+func (dbs *DBStructure) appendAudit(actor Actor, event AuditEvent) {
+	event.ID = len(dbs.AuditEvents) + 1
+	event.ActorID = actor.ID
+	event.ActorType = actor.Type
+	event.RequestID = actor.RequestID
+	event.CreatedAt = time.Now().UTC()
+	dbs.AuditEvents = append(dbs.AuditEvents, event)
+}
+
+type requestIDKey struct{}
+
+// middlewareRequestID tags every request with an id, taken from the
+// X-Request-ID header if the client sent one, and returns it in the response
+func middlewareRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requestID := req.Header.Get("X-Request-ID")
+		if requestID == "" || len(requestID) > 64 {
+			b := make([]byte, 8)
+			rand.Read(b)
+			requestID = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", requestID)
+		ctx := context.WithValue(req.Context(), requestIDKey{}, requestID)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// requestID returns the id middlewareRequestID gave the request
+func requestID(req *http.Request) string {
+	requestID, _ := req.Context().Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// userActor is the authenticated user acting in a request
+func userActor(req *http.Request, userid int) Actor {
+	return Actor{
+		ID:        userid,
+		Type:      ActorUser,
+		RequestID: requestID(req),
+	}
+}
+
+// lists the audit trail, oldest first. Accepts the optional query
+// parameters actor_id, actor_type, action, target_type, target_id, request_id,
+// and since and until as RFC 3339 timestamps.
+func (cfg *apiConfig) GetAuditEvents(w http.ResponseWriter, req *http.Request) {
+	db, err := cfg.DB.loadDB()
+	if err != nil {
+		respondWithError(w, 500, "cannot load db")
+		return
+	}
+	query := req.URL.Query()
+	actorid, err := optionalIntParam(query.Get("actor_id"))
+	if err != nil {
+		respondWithError(w, 400, "actor_id could not be parsed")
+		return
+	}
+	targetid, err := optionalIntParam(query.Get("target_id"))
+	if err != nil {
+		respondWithError(w, 400, "target_id could not be parsed")
+		return
+	}
+	since, err := optionalTimeParam(query.Get("since"))
+	if err != nil {
+		respondWithError(w, 400, "since could not be parsed")
+		return
+	}
+	until, err := optionalTimeParam(query.Get("until"))
+	if err != nil {
+		respondWithError(w, 400, "until could not be parsed")
+		return
+	}
+
+	events := []AuditEvent{}
+	for _, event := range db.AuditEvents {
+		if actorid != 0 && event.ActorID != actorid {
+			continue
+		}
+		if targetid != 0 && event.TargetID != targetid {
+			continue
+		}
+		if !matchesParam(query.Get("actor_type"), event.ActorType) ||
+			!matchesParam(query.Get("action"), event.Action) ||
+			!matchesParam(query.Get("target_type"), event.TargetType) ||
+			!matchesParam(query.Get("request_id"), event.RequestID) {
+			continue
+		}
+		if !since.IsZero() && event.CreatedAt.Before(since) {
+			continue
+		}
+		if !until.IsZero() && event.CreatedAt.After(until) {
+			continue
+		}
+		events = append(events, event)
+	}
+	respondWithJSON(w, 200, events)
+}
+
+// matchesParam reports whether a query parameter is unset or equal to value
+func matchesParam(param, value string) bool {
+	return param == "" || param == value
+}
+
+func optionalIntParam(param string) (int, error) {
+	if param == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(param)
+}
+
+func optionalTimeParam(param string) (time.Time, error) {
+	if param == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, param)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultBookmarkLimit = 20
+const maxBookmarkLimit = 100
+
+// saves a chirp to the bookmarks of the authenticated user
+func (cfg *apiConfig) PostBookmark(w http.ResponseWriter, req *http.Request) {
+	userid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	db, err := cfg.DB.loadDB()
+	if err != nil {
+		respondWithError(w, 500, "cannot load db")
+		return
+	}
+	sid := req.PathValue("chirpID")
+	chirpid, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "Chirp id could not be parsed")
+		return
+	}
+	chirp, exists := db.Chirps[chirpid]
+	if !exists || !db.CanView(userid, chirp, time.Now().UTC()) {
+		respondWithError(w, 404, "Chirp does not exist")
+		return
+	}
+
+	err = cfg.DB.AddBookmark(userid, chirp.ID)
+	if err != nil {
+		fmt.Printf("cannot add bookmark: %s\n", err.Error())
+		respondWithError(w, 500, "cannot add bookmark")
+		return
+	}
+	respondWithJSON(w, 201, chirp)
+}
+
+// removes a chirp from the bookmarks of the authenticated user
+func (cfg *apiConfig) DelBookmark(w http.ResponseWriter, req *http.Request) {
+	userid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	sid := req.PathValue("chirpID")
+	chirpid, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "Chirp id could not be parsed")
+		return
+	}
+
+	err = cfg.DB.DelBookmark(userid, chirpid)
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, 404, "Bookmark does not exist")
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot delete bookmark: %s\n", err.Error())
+		respondWithError(w, 500, "cannot delete bookmark")
+		return
+	}
+	respondWithJSON(w, 200, "Bookmark deleted")
+}
+
+// lists the bookmarks of the authenticated user, most recently saved first.
+// Accepts the optional query parameters limit and offset for pagination.
+func (cfg *apiConfig) GetBookmarks(w http.ResponseWriter, req *http.Request) {
+	userid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	limit, offset, err := parsePagination(req, defaultBookmarkLimit, maxBookmarkLimit)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	db, err := cfg.DB.loadDB()
+	if err != nil {
+		respondWithError(w, 500, "cannot load db")
+		return
+	}
+
+	now := time.Now().UTC()
+	bookmarks := db.Bookmarks[userid]
+	Chirps := []Chirp{}
+	for i := len(bookmarks) - 1; i >= 0; i-- {
+		chirp, exists := db.Chirps[bookmarks[i]]
+		// deleted chirps and chirps the user can no longer see are hidden
+		if !exists || !db.CanView(userid, chirp, now) {
+			continue
+		}
+		Chirps = append(Chirps, db.showChirp(userid, chirp))
+	}
+	respondWithJSON(w, 200, paginate(Chirps, limit, offset))
+}
+
+// parsePagination reads the limit and offset query parameters
+func parsePagination(req *http.Request, defaultLimit, maxLimit int) (limit, offset int, err error) {
+	limit = defaultLimit
+	if slimit := req.URL.Query().Get("limit"); slimit != "" {
+		limit, err = strconv.Atoi(slimit)
+		if err != nil || limit < 1 {
+			return 0, 0, errors.New("limit could not be parsed")
+		}
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	if soffset := req.URL.Query().Get("offset"); soffset != "" {
+		offset, err = strconv.Atoi(soffset)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("offset could not be parsed")
+		}
+	}
+	return limit, offset, nil
+}
+
+// paginate returns the page of chirps described by limit and offset
+func paginate(Chirps []Chirp, limit, offset int) []Chirp {
+	if offset >= len(Chirps) {
+		return []Chirp{}
+	}
+	end := offset + limit
+	if end > len(Chirps) {
+		end = len(Chirps)
+	}
+	return Chirps[offset:end]
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"sync"
 	"time"
 )
@@ -15,8 +16,36 @@ type DB struct {
 }
 
 type DBStructure struct {
-	Chirps map[int]Chirp `json:"chirps"`
-	Users  map[int]User  `json:"users"`
+	Chirps      map[int]Chirp `json:"chirps"`
+	Users       map[int]User  `json:"users"`
+	LastChirpID int           `json:"last_chirp_id"`
+	// chirp ids bookmarked by each user id, oldest first
+	Bookmarks map[int][]int `json:"bookmarks"`
+	// user ids followed by each user id
+	Follows map[int][]int `json:"follows"`
+	// user ids muted and blocked by each user id
+	Mutes  map[int][]int `json:"mutes"`
+	Blocks map[int][]int `json:"blocks"`
+	// rules triggered on chirp creation, only the status of pending events
+	// changes once written
+	ModerationEvents []ModerationEvent `json:"moderation_events"`
+	// reports are stored in order, the id of a report is its position + 1
+	Reports     []Report     `json:"reports"`
+	AuditEvents []AuditEvent `json:"audit_events"`
+	// pending email verifications by token hash
+	EmailVerifications map[string]EmailVerification `json:"email_verifications"`
+	// VerificationMigrated is set once the users from before email
+	// verification existed were marked verified
+	VerificationMigrated bool `json:"verification_migrated"`
+	// pending password resets by token hash
+	PasswordResets map[string]PasswordReset `json:"password_resets"`
+	Sessions       map[int]Session          `json:"sessions"`
+	LastSessionID  int                      `json:"last_session_id"`
+	TwoFactor      map[int]TwoFactor        `json:"two_factor"`
+	// logins waiting for the second factor by challenge token hash
+	LoginChallenges map[string]LoginChallenge `json:"login_challenges"`
+	// failed logins of existing users by normalized email
+	LoginFailures map[string]LoginFailures `json:"login_failures"`
 }
 
 var ErrAlreadyExists = errors.New("already exists")
@@ -34,25 +63,85 @@ func NewDB(path string) (*DB, error) {
 
 }
 
-// CreateChirp creates a new chirp and saves it to disk
-func (db *DB) CreateChirp(body string, userid int) (Chirp, error) {
+// CreateChirp assigns the next free id to a new chirp and saves it to disk
+func (db *DB) CreateChirp(newChirp Chirp) (Chirp, error) {
 	DBStructure, err := db.loadDB()
 	if err != nil {
 		return Chirp{}, err
 	}
-	id := len(DBStructure.Chirps)
+	// chirps get deleted and purged, so the next id can't be derived from
+	// the number of chirps without handing out an id twice
+	id := DBStructure.LastChirpID
+	for chirpid := range DBStructure.Chirps {
+		if chirpid > id {
+			id = chirpid
+		}
+	}
 	id++
 
-	newChirp := Chirp{
-		Body:     body,
-		ID:       id,
-		AuthorID: userid,
+	newChirp.ID = id
+	if newChirp.CreatedAt.IsZero() {
+		newChirp.CreatedAt = time.Now().UTC()
 	}
 	DBStructure.Chirps[id] = newChirp
-	db.writeDB(DBStructure)
+	DBStructure.LastChirpID = id
+	err = db.writeDB(DBStructure)
+	if err != nil {
+		return Chirp{}, err
+	}
 	return newChirp, nil
 }
 
+// PurgeExpiredChirps deletes all chirps whose expiry has passed and
+// returns how many were removed. It runs in the background next to the
+// handlers, so it holds the write lock from load to write and can't
+// overwrite a change made in between.
+func (db *DB) PurgeExpiredChirps() (int, error) {
+	purged := 0
+	err := db.update(func(dbs *DBStructure) error {
+		now := time.Now().UTC()
+		for id, chirp := range dbs.Chirps {
+			if chirp.Expired(now) {
+				dbs.deleteChirp(id)
+				purged++
+			}
+		}
+		if purged == 0 {
+			return errNoChange
+		}
+		return nil
+	})
+	return purged, err
+}
+
+// deleteChirp removes a chirp and everything that references it
+func (dbs DBStructure) deleteChirp(id int) {
+	chirp, exists := dbs.Chirps[id]
+	if !exists {
+		return
+	}
+	delete(dbs.Chirps, id)
+	author, exists := dbs.Users[chirp.AuthorID]
+	if exists && author.PinnedChirpID == id {
+		author.PinnedChirpID = 0
+		dbs.Users[author.ID] = author
+	}
+	for userid, bookmarks := range dbs.Bookmarks {
+		dbs.Bookmarks[userid] = removeID(bookmarks, id)
+	}
+}
+
+// removeID returns ids without any occurrence of id
+func removeID(ids []int, id int) []int {
+	kept := ids[:0]
+	for _, i := range ids {
+		if i != id {
+			kept = append(kept, i)
+		}
+	}
+	return kept
+}
+
 // GetChirps returns all chirps in the database
 func (db *DB) GetChirps() ([]Chirp, error) {
 
@@ -61,7 +150,11 @@ func (db *DB) GetChirps() ([]Chirp, error) {
 		return []Chirp{}, err
 	}
 	Chirps := []Chirp{}
+	now := time.Now().UTC()
 	for _, chirp := range DBStructure.Chirps {
+		if chirp.Expired(now) {
+			continue
+		}
 		Chirps = append(Chirps, chirp)
 	}
 
@@ -73,10 +166,8 @@ func (db *DB) GetChirps() ([]Chirp, error) {
 func (db *DB) ensureDB() error {
 	_, err := os.ReadFile(db.path)
 	if os.IsNotExist(err) {
-		ChirpsDB := DBStructure{
-			Chirps: make(map[int]Chirp),
-			Users:  make(map[int]User),
-		}
+		ChirpsDB := DBStructure{}
+		ChirpsDB.ensureMaps()
 		db.writeDB(ChirpsDB)
 	}
 	return nil
@@ -85,36 +176,126 @@ func (db *DB) ensureDB() error {
 // loadDB reads the database file into memory
 func (db *DB) loadDB() (DBStructure, error) {
 	db.ensureDB()
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	return db.readDB()
+}
+
+// errNoChange is returned by an update function that changed nothing
+var errNoChange = errors.New("nothing changed")
+
+// update loads the database, applies fn and writes the result while holding
+// the write lock, so no other write can happen between the load and the
+// write. Nothing is written when fn returns an error, errNoChange skips the
+// write without failing the update.
+func (db *DB) update(fn func(dbs *DBStructure) error) error {
+	db.ensureDB()
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	dbs, err := db.readDB()
+	if err != nil {
+		return err
+	}
+	err = fn(&dbs)
+	if errors.Is(err, errNoChange) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return db.flushDB(dbs)
+}
+
+// readDB parses the database file, the caller holds the lock
+func (db *DB) readDB() (DBStructure, error) {
 	dat, err := os.ReadFile(db.path)
 	if err != nil {
 		return DBStructure{}, err
 	}
-	db.mux.RLock()
-	defer db.mux.RUnlock()
 	newStructure := DBStructure{}
 	err = json.Unmarshal(dat, &newStructure)
 	if err != nil {
 		return DBStructure{}, err
 	}
+	newStructure.ensureMaps()
+	newStructure.dropLegacyRefreshTokens()
+	newStructure.verifyExistingUsers()
 
 	return newStructure, nil
 }
 
+// ensureMaps initializes maps missing from older database files
+func (dbs *DBStructure) ensureMaps() {
+	if dbs.Chirps == nil {
+		dbs.Chirps = make(map[int]Chirp)
+	}
+	if dbs.Users == nil {
+		dbs.Users = make(map[int]User)
+	}
+	if dbs.Bookmarks == nil {
+		dbs.Bookmarks = make(map[int][]int)
+	}
+	if dbs.Follows == nil {
+		dbs.Follows = make(map[int][]int)
+	}
+	if dbs.Mutes == nil {
+		dbs.Mutes = make(map[int][]int)
+	}
+	if dbs.Blocks == nil {
+		dbs.Blocks = make(map[int][]int)
+	}
+	if dbs.EmailVerifications == nil {
+		dbs.EmailVerifications = make(map[string]EmailVerification)
+	}
+	if dbs.PasswordResets == nil {
+		dbs.PasswordResets = make(map[string]PasswordReset)
+	}
+	if dbs.Sessions == nil {
+		dbs.Sessions = make(map[int]Session)
+	}
+	if dbs.TwoFactor == nil {
+		dbs.TwoFactor = make(map[int]TwoFactor)
+	}
+	if dbs.LoginChallenges == nil {
+		dbs.LoginChallenges = make(map[string]LoginChallenge)
+	}
+	if dbs.LoginFailures == nil {
+		dbs.LoginFailures = make(map[string]LoginFailures)
+	}
+}
+
+// dropLegacyRefreshTokens invalidates refresh tokens of older database
+// files. They were stored in plain text on the user or the session and all
+// had the same value, so they can't be trusted and the users log in again.
+func (dbs *DBStructure) dropLegacyRefreshTokens() {
+	for id, user := range dbs.Users {
+		if user.RefreshToken != "" || user.RefreshExpiration != nil {
+			user.RefreshToken = ""
+			user.RefreshExpiration = nil
+			dbs.Users[id] = user
+		}
+	}
+	for id, session := range dbs.Sessions {
+		if session.RefreshTokenHash == "" {
+			delete(dbs.Sessions, id)
+		}
+	}
+}
+
 // writeDB writes the database file to disk
 func (db *DB) writeDB(dbStructure DBStructure) error {
-	bytedb, err := json.Marshal(dbStructure)
-	if err != nil {
-		return err
-	}
 	db.mux.Lock()
 	defer db.mux.Unlock()
-	err = os.WriteFile(db.path, bytedb, 0644)
+	return db.flushDB(dbStructure)
+}
+
+// flushDB writes the database file, the caller holds the write lock
+func (db *DB) flushDB(dbStructure DBStructure) error {
+	bytedb, err := json.Marshal(dbStructure)
 	if err != nil {
 		return err
 	}
-
-	return nil
-
+	return os.WriteFile(db.path, bytedb, 0644)
 }
 
 func (db *DB) CreateUser(email string, password string) (User, error) {
@@ -122,10 +303,17 @@ func (db *DB) CreateUser(email string, password string) (User, error) {
 	if err != nil {
 		return User{}, err
 	}
-	//if _, err := db.GetUser(email); !errors.Is(err, ErrNotExist) {
-	//	return User{}, ErrAlreadyExists
-	//}
-	id := len(DBStructure.Users)
+	email = NormalizeEmail(email)
+	if DBStructure.emailTaken(email, 0) {
+		return User{}, ErrAlreadyExists
+	}
+	// merged users leave gaps, so the next id can't be derived from the number of users
+	id := 0
+	for userid := range DBStructure.Users {
+		if userid > id {
+			id = userid
+		}
+	}
 	id++
 
 	newUser := User{
@@ -162,8 +350,9 @@ func (db *DB) GetUserbyMail(email string) (User, error) {
 		return User{}, err
 	}
 	var foundUser User
+	email = NormalizeEmail(email)
 	for _, user := range DBStructure.Users {
-		if user.Email == email {
+		if NormalizeEmail(user.Email) == email {
 			foundUser = user
 			return foundUser, nil
 		}
@@ -172,25 +361,116 @@ func (db *DB) GetUserbyMail(email string) (User, error) {
 
 }
 
-// GetUser returns a user from the database
-func (db *DB) GetUserbyRefresh(refreshtoken string) (User, error) {
+// UpdateUser updates a user in the database
+func (db *DB) UpdateUser(id int, newUser User) error {
 	DBStructure, err := db.loadDB()
 	if err != nil {
-		return User{}, err
+		return err
 	}
-	var foundUser User
-	for _, user := range DBStructure.Users {
-		if user.RefreshToken == refreshtoken {
-			foundUser = user
-			return foundUser, nil
+
+	user, exists := DBStructure.Users[id]
+	if !exists {
+		err := errors.New("user not found in DB")
+		return err
+	}
+	newUser.Email = NormalizeEmail(newUser.Email)
+	if DBStructure.emailTaken(newUser.Email, id) {
+		return ErrAlreadyExists
+	}
+	fmt.Printf("updating user with mail: %s\n", user.Email)
+	DBStructure.Users[id] = newUser
+	db.writeDB(DBStructure)
+	return nil
+}
+
+// Pin a chirp on the profile of a User, a chirpid of 0 removes the pin
+func (db *DB) SetPinnedChirp(id int, chirpid int) error {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+
+	user, exists := DBStructure.Users[id]
+	if !exists {
+		err := errors.New("user not found in DB")
+		return err
+	}
+	user.PinnedChirpID = chirpid
+
+	DBStructure.Users[id] = user
+	return db.writeDB(DBStructure)
+}
+
+// AddBookmark saves a chirp for a User, bookmarking a chirp twice is a no-op
+func (db *DB) AddBookmark(id int, chirpid int) error {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, exists := DBStructure.Users[id]; !exists {
+		return errors.New("user not found in DB")
+	}
+	if _, exists := DBStructure.Chirps[chirpid]; !exists {
+		return ErrNotExist
+	}
+	for _, bookmark := range DBStructure.Bookmarks[id] {
+		if bookmark == chirpid {
+			return nil
 		}
 	}
-	return User{}, errors.New("refresh Token does not exist in DB")
+	DBStructure.Bookmarks[id] = append(DBStructure.Bookmarks[id], chirpid)
+	return db.writeDB(DBStructure)
+}
 
+// DelBookmark removes a saved chirp of a User
+func (db *DB) DelBookmark(id int, chirpid int) error {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	bookmarks := DBStructure.Bookmarks[id]
+	kept := removeID(bookmarks, chirpid)
+	if len(kept) == len(bookmarks) {
+		return ErrNotExist
+	}
+	DBStructure.Bookmarks[id] = kept
+	return db.writeDB(DBStructure)
 }
 
-// UpdateUser updates a user in the database
-func (db *DB) UpdateUser(id int, newUser User) error {
+// Follow lets the user follower follow the user followee
+func (db *DB) Follow(follower int, followee int) error {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, exists := DBStructure.Users[followee]; !exists {
+		return ErrNotExist
+	}
+	if DBStructure.IsBlocked(followee, follower) {
+		return ErrBlocked
+	}
+	if DBStructure.IsFollowing(follower, followee) {
+		return nil
+	}
+	DBStructure.Follows[follower] = append(DBStructure.Follows[follower], followee)
+	return db.writeDB(DBStructure)
+}
+
+// Unfollow removes followee from the users followed by follower
+func (db *DB) Unfollow(follower int, followee int) error {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if !DBStructure.IsFollowing(follower, followee) {
+		return ErrNotExist
+	}
+	DBStructure.Follows[follower] = removeID(DBStructure.Follows[follower], followee)
+	return db.writeDB(DBStructure)
+}
+
+// SetShowSensitive stores whether a User wants sensitive chirps shown inline
+func (db *DB) SetShowSensitive(id int, show bool) error {
 	DBStructure, err := db.loadDB()
 	if err != nil {
 		return err
@@ -201,35 +481,445 @@ func (db *DB) UpdateUser(id int, newUser User) error {
 		err := errors.New("user not found in DB")
 		return err
 	}
-	fmt.Printf("updating user with mail: %s\n", user.Email)
-	DBStructure.Users[id] = newUser
-	db.writeDB(DBStructure)
+	user.ShowSensitive = show
+
+	DBStructure.Users[id] = user
+	return db.writeDB(DBStructure)
+}
+
+// AddModerationEvents appends events to the moderation log and assigns their ids
+func (db *DB) AddModerationEvents(events []ModerationEvent) error {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		event.ID = len(DBStructure.ModerationEvents) + 1
+		DBStructure.ModerationEvents = append(DBStructure.ModerationEvents, event)
+	}
+	return db.writeDB(DBStructure)
+}
+
+// ResolveModerationEvent applies the resolution to the chirp of a pending
+// event, resolves all pending events of that chirp and writes the action to
+// the audit trail
+func (db *DB) ResolveModerationEvent(id int, actor Actor, resolution string) (ModerationEvent, error) {
+	var resolved ModerationEvent
+	err := db.update(func(dbs *DBStructure) error {
+		if id < 1 || id > len(dbs.ModerationEvents) {
+			return ErrNotExist
+		}
+		event := dbs.ModerationEvents[id-1]
+		if event.Status != ModerationPending {
+			return ErrAlreadyResolved
+		}
+		// events queued for a chirp that was never stored only resolve
+		// themselves, every rejected chirp shares the id 0
+		if event.ChirpID == 0 {
+			if resolution != ResolutionDismiss && resolution != ResolutionHideChirp {
+				return ErrInvalidModerationResolution
+			}
+			dbs.resolveModerationEvent(id-1, resolution, actor)
+			resolved = dbs.ModerationEvents[id-1]
+			return nil
+		}
+		err := dbs.moderateChirp(event.ChirpID, resolution, actor, fmt.Sprintf("moderation event %d", event.ID))
+		if err != nil {
+			return err
+		}
+		resolved = dbs.ModerationEvents[id-1]
+		return nil
+	})
+	return resolved, err
+}
+
+// moderateChirp applies a resolution to a chirp and resolves its pending
+// moderation events. Dismissing releases a chirp held for review, hiding
+// it replaces the hold.
+func (dbs *DBStructure) moderateChirp(chirpid int, resolution string, actor Actor, reason string) error {
+	chirp, exists := dbs.Chirps[chirpid]
+	before := auditChirpState(chirp)
+	switch resolution {
+	case ResolutionDismiss:
+		if exists && chirp.HeldForReview {
+			chirp.HeldForReview = false
+			dbs.Chirps[chirpid] = chirp
+			dbs.appendAudit(actor, AuditEvent{
+				Action:     "chirp.approve",
+				TargetType: "chirp",
+				TargetID:   chirpid,
+				Details:    "approved because of " + reason,
+				Before:     before,
+				After:      auditChirpState(chirp),
+			})
+		}
+	case ResolutionHideChirp:
+		if exists {
+			chirp.Hidden = true
+			chirp.HeldForReview = false
+			dbs.Chirps[chirpid] = chirp
+			dbs.appendAudit(actor, AuditEvent{
+				Action:     "chirp.hide",
+				TargetType: "chirp",
+				TargetID:   chirpid,
+				Details:    "hidden because of " + reason,
+				Before:     before,
+				After:      auditChirpState(chirp),
+			})
+		}
+	default:
+		return ErrInvalidModerationResolution
+	}
+	dbs.resolveModerationEvents(chirpid, resolution, actor)
 	return nil
 }
 
-// Set a new refreshToken for a User
-func (db *DB) SetRefreshToken(id int, newtoken string, expiresIn time.Duration) error {
+// resolveModerationEvents resolves all pending events of a chirp
+func (dbs *DBStructure) resolveModerationEvents(chirpid int, resolution string, actor Actor) {
+	if chirpid == 0 {
+		return
+	}
+	for i, event := range dbs.ModerationEvents {
+		if event.ChirpID == chirpid && event.Status == ModerationPending {
+			dbs.resolveModerationEvent(i, resolution, actor)
+		}
+	}
+}
+
+// resolveModerationEvent resolves the event at index i
+func (dbs *DBStructure) resolveModerationEvent(i int, resolution string, actor Actor) {
+	now := time.Now().UTC()
+	event := dbs.ModerationEvents[i]
+	event.Status = ModerationResolved
+	event.Resolution = resolution
+	event.ResolvedBy = actor.ID
+	event.ResolvedAt = &now
+	dbs.ModerationEvents[i] = event
+	dbs.appendAudit(actor, AuditEvent{
+		Action:     "moderation." + resolution,
+		TargetType: "moderation_event",
+		TargetID:   event.ID,
+		Details:    fmt.Sprintf("chirp %d", event.ChirpID),
+	})
+}
+
+// CreateReport stores a new open report. If the reporter already reported
+// the chirp the existing report is returned and created is false.
+func (db *DB) CreateReport(newReport Report) (report Report, created bool, err error) {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return Report{}, false, err
+	}
+	for _, report := range DBStructure.Reports {
+		if report.ChirpID == newReport.ChirpID && report.ReporterID == newReport.ReporterID {
+			return report, false, nil
+		}
+	}
+	newReport.ID = len(DBStructure.Reports) + 1
+	newReport.Status = ReportOpen
+	newReport.CreatedAt = time.Now().UTC()
+	DBStructure.Reports = append(DBStructure.Reports, newReport)
+	err = db.writeDB(DBStructure)
+	if err != nil {
+		return Report{}, false, err
+	}
+	return newReport, true, nil
+}
+
+// ResolveReport applies the resolution to the reported chirp, closes all
+// open reports of that chirp and writes the action to the audit trail
+func (db *DB) ResolveReport(id int, actor Actor, resolution string, suspendFor time.Duration) (Report, error) {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return Report{}, err
+	}
+	if id < 1 || id > len(DBStructure.Reports) {
+		return Report{}, ErrNotExist
+	}
+	report := DBStructure.Reports[id-1]
+	if report.Status != ReportOpen {
+		return Report{}, ErrAlreadyResolved
+	}
+
+	chirp, exists := DBStructure.Chirps[report.ChirpID]
+	switch resolution {
+	case ResolutionDismiss:
+	case ResolutionHideChirp:
+		if exists {
+			before := auditChirpState(chirp)
+			chirp.Hidden = true
+			DBStructure.Chirps[chirp.ID] = chirp
+			DBStructure.appendAudit(actor, AuditEvent{
+				Action:     "chirp.hide",
+				TargetType: "chirp",
+				TargetID:   chirp.ID,
+				Details:    fmt.Sprintf("hidden because of report %d", report.ID),
+				Before:     before,
+				After:      auditChirpState(chirp),
+			})
+		}
+	case ResolutionSuspendAuthor:
+		if !exists {
+			return Report{}, errors.New("chirp of the report does not exist anymore")
+		}
+		author, exists := DBStructure.Users[chirp.AuthorID]
+		if !exists {
+			return Report{}, errors.New("user not found in DB")
+		}
+		moderator := DBStructure.Users[actor.ID]
+		if roleLevels[author.RoleOrDefault()] >= roleLevels[moderator.RoleOrDefault()] {
+			return Report{}, ErrOutranked
+		}
+		before := auditUserState(author)
+		author.Suspend(suspendFor, false)
+		DBStructure.Users[author.ID] = author
+		DBStructure.appendAudit(actor, AuditEvent{
+			Action:     "user.suspend",
+			TargetType: "user",
+			TargetID:   author.ID,
+			Details:    fmt.Sprintf("suspended because of report %d", report.ID),
+			Before:     before,
+			After:      auditUserState(author),
+		})
+	default:
+		return Report{}, ErrInvalidResolution
+	}
+
+	now := time.Now().UTC()
+	for i, open := range DBStructure.Reports {
+		if open.ChirpID != report.ChirpID || open.Status != ReportOpen {
+			continue
+		}
+		open.Status = ReportResolved
+		open.Resolution = resolution
+		open.ResolvedBy = actor.ID
+		open.ResolvedAt = &now
+		DBStructure.Reports[i] = open
+		DBStructure.appendAudit(actor, AuditEvent{
+			Action:     "report." + resolution,
+			TargetType: "report",
+			TargetID:   open.ID,
+			Details:    fmt.Sprintf("chirp %d", open.ChirpID),
+		})
+	}
+
+	err = db.writeDB(DBStructure)
+	if err != nil {
+		return Report{}, err
+	}
+	return DBStructure.Reports[id-1], nil
+}
+
+// SetRole changes the role of a User and writes the change to the audit trail
+func (db *DB) SetRole(id int, actor Actor, role string) (User, error) {
+	if _, ok := roleLevels[role]; !ok {
+		return User{}, ErrInvalidRole
+	}
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	user, exists := DBStructure.Users[id]
+	if !exists {
+		return User{}, ErrNotExist
+	}
+	before := auditUserState(user)
+	user.Role = role
+
+	DBStructure.Users[id] = user
+	DBStructure.appendAudit(actor, AuditEvent{
+		Action:     "user.role",
+		TargetType: "user",
+		TargetID:   id,
+		Before:     before,
+		After:      auditUserState(user),
+	})
+	return user, db.writeDB(DBStructure)
+}
+
+// SuspendUser suspends a User for the given duration, 0 suspends
+// permanently, and writes the change to the audit trail
+func (db *DB) SuspendUser(id int, actor Actor, duration time.Duration, hideChirps bool, reason string) (User, error) {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	user, exists := DBStructure.Users[id]
+	if !exists {
+		return User{}, ErrNotExist
+	}
+	before := auditUserState(user)
+	user.Suspend(duration, hideChirps)
+
+	DBStructure.Users[id] = user
+	DBStructure.appendAudit(actor, AuditEvent{
+		Action:     "user.suspend",
+		TargetType: "user",
+		TargetID:   id,
+		Details:    reason,
+		Before:     before,
+		After:      auditUserState(user),
+	})
+	return user, db.writeDB(DBStructure)
+}
+
+// ReinstateUser lifts the suspension of a User and writes the change to the audit trail
+func (db *DB) ReinstateUser(id int, actor Actor) (User, error) {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	user, exists := DBStructure.Users[id]
+	if !exists {
+		return User{}, ErrNotExist
+	}
+	before := auditUserState(user)
+	user.Reinstate()
+
+	DBStructure.Users[id] = user
+	DBStructure.appendAudit(actor, AuditEvent{
+		Action:     "user.reinstate",
+		TargetType: "user",
+		TargetID:   id,
+		Before:     before,
+		After:      auditUserState(user),
+	})
+	return user, db.writeDB(DBStructure)
+}
+
+// SetShadowban sets or lifts the shadowban of a User and writes the change to the audit trail
+func (db *DB) SetShadowban(id int, actor Actor, shadowbanned bool) (User, error) {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	user, exists := DBStructure.Users[id]
+	if !exists {
+		return User{}, ErrNotExist
+	}
+	before := auditUserState(user)
+	user.Shadowbanned = shadowbanned
+
+	action := "user.shadowban"
+	if !shadowbanned {
+		action = "user.unshadowban"
+	}
+	DBStructure.Users[id] = user
+	DBStructure.appendAudit(actor, AuditEvent{
+		Action:     action,
+		TargetType: "user",
+		TargetID:   id,
+		Before:     before,
+		After:      auditUserState(user),
+	})
+	return user, db.writeDB(DBStructure)
+}
+
+// AddToUserList adds otherid to the mutes or blocks of a User. Blocking
+// also ends the follow of the blocked user.
+func (db *DB) AddToUserList(list string, id int, otherid int) error {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, exists := DBStructure.Users[otherid]; !exists {
+		return ErrNotExist
+	}
+	lists := DBStructure.userList(list)
+	if !contains(lists[id], otherid) {
+		lists[id] = append(lists[id], otherid)
+	}
+	if list == ListBlocks {
+		DBStructure.Follows[otherid] = removeID(DBStructure.Follows[otherid], id)
+	}
+	return db.writeDB(DBStructure)
+}
+
+// RemoveFromUserList removes otherid from the mutes or blocks of a User
+func (db *DB) RemoveFromUserList(list string, id int, otherid int) error {
 	DBStructure, err := db.loadDB()
 	if err != nil {
 		return err
 	}
+	lists := DBStructure.userList(list)
+	if !contains(lists[id], otherid) {
+		return ErrNotExist
+	}
+	lists[id] = removeID(lists[id], otherid)
+	return db.writeDB(DBStructure)
+}
+
+// ApproveChirp releases a chirp held for review and resolves its pending
+// moderation events
+func (db *DB) ApproveChirp(id int, actor Actor) (Chirp, error) {
+	return db.reviewHeldChirp(id, actor, ResolutionDismiss)
+}
+
+// RejectChirp hides a chirp held for review from everyone but its author
+// and resolves its pending moderation events
+func (db *DB) RejectChirp(id int, actor Actor) (Chirp, error) {
+	return db.reviewHeldChirp(id, actor, ResolutionHideChirp)
+}
+
+func (db *DB) reviewHeldChirp(id int, actor Actor, resolution string) (Chirp, error) {
+	var chirp Chirp
+	err := db.update(func(dbs *DBStructure) error {
+		held, exists := dbs.Chirps[id]
+		if !exists || !held.HeldForReview {
+			return ErrNotExist
+		}
+		err := dbs.moderateChirp(id, resolution, actor, "review")
+		if err != nil {
+			return err
+		}
+		chirp = dbs.Chirps[id]
+		return nil
+	})
+	return chirp, err
+}
+
+// AddKeywordFilter adds a filter to a User and drops filters that expired
+func (db *DB) AddKeywordFilter(id int, filter KeywordFilter) (KeywordFilter, error) {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return KeywordFilter{}, err
+	}
 
 	user, exists := DBStructure.Users[id]
 	if !exists {
 		err := errors.New("user not found in DB")
-		return err
+		return KeywordFilter{}, err
 	}
-	fmt.Printf("setting new refresh_token for user with mail: %s\n", user.Email)
-	user.RefreshToken = newtoken
-	user.RefreshExpiration = time.Now().UTC().Add(expiresIn)
+	now := time.Now().UTC()
+	// filters get deleted and expire, so like chirps the next id comes from
+	// a counter and an id is never handed out twice
+	filter.ID = user.LastFilterID
+	filters := []KeywordFilter{}
+	for _, existing := range user.KeywordFilters {
+		if existing.ID > filter.ID {
+			filter.ID = existing.ID
+		}
+		if !existing.Expired(now) {
+			filters = append(filters, existing)
+		}
+	}
+	if len(filters) >= maxKeywordFilters {
+		return KeywordFilter{}, ErrTooManyFilters
+	}
+	filter.ID++
+	user.KeywordFilters = append(filters, filter)
+	user.LastFilterID = filter.ID
 
 	DBStructure.Users[id] = user
-	db.writeDB(DBStructure)
-	return nil
+	return filter, db.writeDB(DBStructure)
 }
 
-// Delete refreshToken for a User
-func (db *DB) DelRefreshToken(id int) error {
+// DelKeywordFilter removes a filter of a User
+func (db *DB) DelKeywordFilter(id int, filterid int) error {
 	DBStructure, err := db.loadDB()
 	if err != nil {
 		return err
@@ -240,11 +930,518 @@ func (db *DB) DelRefreshToken(id int) error {
 		err := errors.New("user not found in DB")
 		return err
 	}
-	fmt.Printf("revoking refresh_token for user with mail: %s\n", user.Email)
-	user.RefreshToken = ""
-	user.RefreshExpiration = time.Now().UTC()
+	for i, filter := range user.KeywordFilters {
+		if filter.ID == filterid {
+			user.KeywordFilters = append(user.KeywordFilters[:i], user.KeywordFilters[i+1:]...)
+			DBStructure.Users[id] = user
+			return db.writeDB(DBStructure)
+		}
+	}
+	return ErrNotExist
+}
+
+// RecordAudit writes an event to the audit trail for actions that change
+// nothing in the database
+func (db *DB) RecordAudit(actor Actor, event AuditEvent) error {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	DBStructure.appendAudit(actor, event)
+	return db.writeDB(DBStructure)
+}
+
+// UpgradeUser makes a User a Chirpy Red member and writes the change to the audit trail
+func (db *DB) UpgradeUser(id int, actor Actor) (User, error) {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	user, exists := DBStructure.Users[id]
+	if !exists {
+		err := errors.New("user not found in DB")
+		return User{}, err
+	}
+	before := auditUserState(user)
+	user.IsChirpyRed = true
 
 	DBStructure.Users[id] = user
-	db.writeDB(DBStructure)
-	return nil
+	DBStructure.appendAudit(actor, AuditEvent{
+		Action:     "user.upgrade",
+		TargetType: "user",
+		TargetID:   id,
+		Before:     before,
+		After:      auditUserState(user),
+	})
+	return user, db.writeDB(DBStructure)
+}
+
+// CreateVerification stores a verification token hash for the email of a
+// user and drops the older tokens of the user
+func (db *DB) CreateVerification(tokenHash string, userid int, email string, ttl time.Duration) error {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, exists := DBStructure.Users[userid]; !exists {
+		return errors.New("user not found in DB")
+	}
+	for hash, verification := range DBStructure.EmailVerifications {
+		if verification.UserID == userid {
+			delete(DBStructure.EmailVerifications, hash)
+		}
+	}
+	DBStructure.EmailVerifications[tokenHash] = EmailVerification{
+		UserID:    userid,
+		Email:     email,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	}
+	return db.writeDB(DBStructure)
+}
+
+// VerifyEmail marks the email of the user a token was issued for as
+// verified. Tokens are single use, expired tokens and tokens for an email
+// the user changed since return ErrNotExist.
+func (db *DB) VerifyEmail(tokenHash string) (User, error) {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+	verification, exists := DBStructure.EmailVerifications[tokenHash]
+	if !exists {
+		return User{}, ErrNotExist
+	}
+	delete(DBStructure.EmailVerifications, tokenHash)
+	user, exists := DBStructure.Users[verification.UserID]
+	if !exists || user.Email != verification.Email || !verification.ExpiresAt.After(time.Now().UTC()) {
+		db.writeDB(DBStructure)
+		return User{}, ErrNotExist
+	}
+	user.EmailVerified = true
+	DBStructure.Users[user.ID] = user
+	return user, db.writeDB(DBStructure)
+}
+
+// CreatePasswordReset stores a reset token hash for a user and drops the
+// older reset tokens of the user
+func (db *DB) CreatePasswordReset(tokenHash string, userid int, email string, ttl time.Duration) error {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, exists := DBStructure.Users[userid]; !exists {
+		return errors.New("user not found in DB")
+	}
+	for hash, reset := range DBStructure.PasswordResets {
+		if reset.UserID == userid {
+			delete(DBStructure.PasswordResets, hash)
+		}
+	}
+	DBStructure.PasswordResets[tokenHash] = PasswordReset{
+		UserID:    userid,
+		Email:     email,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	}
+	return db.writeDB(DBStructure)
+}
+
+// ResetPassword sets the password of the user a reset token was issued for
+// and revokes all sessions of the user. Tokens are single use, expired
+// tokens and tokens for an email the user changed since return ErrNotExist.
+func (db *DB) ResetPassword(tokenHash string, password string) (User, error) {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+	reset, exists := DBStructure.PasswordResets[tokenHash]
+	if !exists {
+		return User{}, ErrNotExist
+	}
+	delete(DBStructure.PasswordResets, tokenHash)
+	user, exists := DBStructure.Users[reset.UserID]
+	if !exists || user.Email != reset.Email || !reset.ExpiresAt.After(time.Now().UTC()) {
+		db.writeDB(DBStructure)
+		return User{}, ErrNotExist
+	}
+	user.Password = password
+	DBStructure.deleteSessions(user.ID, 0)
+	// the token arrived by mail, so the address works
+	user.EmailVerified = true
+	DBStructure.Users[user.ID] = user
+	return user, db.writeDB(DBStructure)
+}
+
+// CreateSession stores a new session for a user
+func (db *DB) CreateSession(session Session) (Session, error) {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return Session{}, err
+	}
+	if _, exists := DBStructure.Users[session.UserID]; !exists {
+		return Session{}, errors.New("user not found in DB")
+	}
+	now := time.Now().UTC()
+	DBStructure.LastSessionID++
+	session.ID = DBStructure.LastSessionID
+	session.CreatedAt = now
+	session.LastUsedAt = now
+	DBStructure.Sessions[session.ID] = session
+	return session, db.writeDB(DBStructure)
+}
+
+// GetSessionByRefresh returns the session of a refresh token hash
+func (db *DB) GetSessionByRefresh(tokenHash string) (Session, error) {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return Session{}, err
+	}
+	for _, session := range DBStructure.Sessions {
+		if session.RefreshTokenHash == tokenHash {
+			return session, nil
+		}
+	}
+	return Session{}, errors.New("refresh Token does not exist in DB")
+}
+
+// SessionExists reports whether a session of the user has not been revoked
+func (db *DB) SessionExists(id int, userid int) (bool, error) {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return false, err
+	}
+	session, exists := DBStructure.Sessions[id]
+	return exists && session.UserID == userid, nil
+}
+
+// RotateSession replaces the refresh token of the session it belongs to
+// and slides its expiry. A token that was already rotated revokes the
+// whole session and returns ErrRefreshTokenReused, as either the user or
+// an attacker holds a stolen copy and there is no telling which one.
+// Sessions of suspended users are left untouched and return ErrSuspended.
+// Lookup and rotation happen under one lock, so a token can only be
+// rotated once.
+func (db *DB) RotateSession(tokenHash string, newTokenHash string, ip string, userAgent string, actor Actor) (Session, error) {
+	var rotated Session
+	reused := false
+	err := db.update(func(dbs *DBStructure) error {
+		now := time.Now().UTC()
+		for id, session := range dbs.Sessions {
+			if session.RefreshTokenHash != tokenHash {
+				continue
+			}
+			if !session.ExpiresAt.After(now) {
+				return ErrNotExist
+			}
+			user, exists := dbs.Users[session.UserID]
+			if !exists {
+				return ErrNotExist
+			}
+			if user.IsSuspended(now) {
+				return ErrSuspended
+			}
+			session.RotatedTokenHashes = append(session.RotatedTokenHashes, session.RefreshTokenHash)
+			if len(session.RotatedTokenHashes) > maxRotatedTokenHashes {
+				session.RotatedTokenHashes = session.RotatedTokenHashes[len(session.RotatedTokenHashes)-maxRotatedTokenHashes:]
+			}
+			session.RefreshTokenHash = newTokenHash
+			session.LastUsedAt = now
+			session.ExpiresAt = now.Add(refreshTokenTTL)
+			session.IP = ip
+			session.UserAgent = userAgent
+			dbs.Sessions[id] = session
+			rotated = session
+			return nil
+		}
+		for id, session := range dbs.Sessions {
+			if !slices.Contains(session.RotatedTokenHashes, tokenHash) {
+				continue
+			}
+			delete(dbs.Sessions, id)
+			dbs.appendAudit(actor, AuditEvent{
+				Action:     "session.token_reuse",
+				TargetType: "user",
+				TargetID:   session.UserID,
+				Details:    fmt.Sprintf("rotated refresh token of session %d replayed from %s, session revoked", id, ip),
+			})
+			rotated = session
+			reused = true
+			return nil
+		}
+		return ErrNotExist
+	})
+	if err != nil {
+		return Session{}, err
+	}
+	if reused {
+		return rotated, ErrRefreshTokenReused
+	}
+	return rotated, nil
+}
+
+// DelSession revokes a session of a user
+func (db *DB) DelSession(userid int, id int) error {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	session, exists := DBStructure.Sessions[id]
+	if !exists || session.UserID != userid {
+		return ErrNotExist
+	}
+	delete(DBStructure.Sessions, id)
+	return db.writeDB(DBStructure)
+}
+
+// DelSessions revokes all sessions of a user but keepID and returns how
+// many were revoked
+func (db *DB) DelSessions(userid int, keepID int) (int, error) {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return 0, err
+	}
+	revoked := DBStructure.deleteSessions(userid, keepID)
+	return revoked, db.writeDB(DBStructure)
+}
+
+func (dbs *DBStructure) deleteSessions(userid int, keepID int) int {
+	revoked := 0
+	for id, session := range dbs.Sessions {
+		if session.UserID == userid && id != keepID {
+			delete(dbs.Sessions, id)
+			revoked++
+		}
+	}
+	return revoked
+}
+
+// TwoFactorEnabled reports whether a user logs in with a second factor
+func (db *DB) TwoFactorEnabled(id int) (bool, error) {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return false, err
+	}
+	return DBStructure.TwoFactor[id].Enabled(), nil
+}
+
+// SetPendingTwoFactor stores a secret that waits for its first code
+func (db *DB) SetPendingTwoFactor(id int, secret string) error {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	twoFactor := DBStructure.TwoFactor[id]
+	if twoFactor.Enabled() {
+		return ErrAlreadyExists
+	}
+	twoFactor.PendingSecret = secret
+	DBStructure.TwoFactor[id] = twoFactor
+	return db.writeDB(DBStructure)
+}
+
+// ConfirmTwoFactor enables the pending secret of a user when code matches
+// it and replaces the recovery codes
+func (db *DB) ConfirmTwoFactor(id int, code string, recoveryCodeHashes []string) error {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	twoFactor, exists := DBStructure.TwoFactor[id]
+	if !exists || twoFactor.PendingSecret == "" {
+		return ErrNotExist
+	}
+	step, ok := verifyTOTP(twoFactor.PendingSecret, code, time.Now().UTC(), 0)
+	if !ok {
+		return ErrInvalidCode
+	}
+	DBStructure.TwoFactor[id] = TwoFactor{
+		Secret:             twoFactor.PendingSecret,
+		RecoveryCodeHashes: recoveryCodeHashes,
+		LastStep:           step,
+	}
+	return db.writeDB(DBStructure)
+}
+
+// DisableTwoFactor removes the second factor of a user, code is a current
+// TOTP code or a recovery code
+func (db *DB) DisableTwoFactor(id int, code string) error {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if !DBStructure.TwoFactor[id].Enabled() {
+		return ErrNotExist
+	}
+	if !DBStructure.checkSecondFactor(id, code, code) {
+		return ErrInvalidCode
+	}
+	delete(DBStructure.TwoFactor, id)
+	return db.writeDB(DBStructure)
+}
+
+// checkSecondFactor accepts a TOTP code or an unused recovery code of a
+// user. The code is used up: the time step of a TOTP code can't be used
+// again and a recovery code is removed.
+func (dbs *DBStructure) checkSecondFactor(id int, code string, recoveryCode string) bool {
+	twoFactor := dbs.TwoFactor[id]
+	if code != "" {
+		if step, ok := verifyTOTP(twoFactor.Secret, code, time.Now().UTC(), twoFactor.LastStep); ok {
+			twoFactor.LastStep = step
+			dbs.TwoFactor[id] = twoFactor
+			return true
+		}
+	}
+	if recoveryCode != "" {
+		hash := hashToken(normalizeRecoveryCode(recoveryCode))
+		if i := slices.Index(twoFactor.RecoveryCodeHashes, hash); i >= 0 {
+			twoFactor.RecoveryCodeHashes = slices.Delete(twoFactor.RecoveryCodeHashes, i, i+1)
+			dbs.TwoFactor[id] = twoFactor
+			return true
+		}
+	}
+	return false
+}
+
+// CreateLoginChallenge stores a login waiting for the second factor
+func (db *DB) CreateLoginChallenge(tokenHash string, challenge LoginChallenge) error {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for hash, other := range DBStructure.LoginChallenges {
+		if !other.ExpiresAt.After(now) {
+			delete(DBStructure.LoginChallenges, hash)
+		}
+	}
+	DBStructure.LoginChallenges[tokenHash] = challenge
+	return db.writeDB(DBStructure)
+}
+
+// AnswerLoginChallenge checks the second factor for a login challenge and
+// removes the challenge once answered. Wrong answers return ErrInvalidCode
+// and count as failed logins of the account, so they lock it like wrong
+// passwords no matter how many challenges were started. After too many on
+// one challenge the challenge is dropped, once the account is locked all
+// its challenges are and ErrLoginLocked is returned until the lock ends.
+func (db *DB) AnswerLoginChallenge(tokenHash string, code string, recoveryCode string) (LoginChallenge, error) {
+	var answered LoginChallenge
+	var answerErr error
+	err := db.update(func(dbs *DBStructure) error {
+		now := time.Now().UTC()
+		challenge, exists := dbs.LoginChallenges[tokenHash]
+		if !exists {
+			return ErrNotExist
+		}
+		answered = LoginChallenge{UserID: challenge.UserID}
+		if !challenge.ExpiresAt.After(now) || !dbs.TwoFactor[challenge.UserID].Enabled() {
+			delete(dbs.LoginChallenges, tokenHash)
+			answerErr = ErrNotExist
+			return nil
+		}
+		email := NormalizeEmail(dbs.Users[challenge.UserID].Email)
+		if _, locked := dbs.LoginFailures[email].Locked(now); locked {
+			dbs.deleteLoginChallenges(challenge.UserID)
+			answerErr = ErrLoginLocked
+			return nil
+		}
+		if !dbs.checkSecondFactor(challenge.UserID, code, recoveryCode) {
+			failures := dbs.LoginFailures[email].fail(accountFailureLimit, now)
+			dbs.LoginFailures[email] = failures
+			challenge.Attempts++
+			dbs.LoginChallenges[tokenHash] = challenge
+			if challenge.Attempts >= maxChallengeAttempts {
+				delete(dbs.LoginChallenges, tokenHash)
+			}
+			if _, locked := failures.Locked(now); locked {
+				dbs.deleteLoginChallenges(challenge.UserID)
+			}
+			answerErr = ErrInvalidCode
+			return nil
+		}
+		delete(dbs.LoginChallenges, tokenHash)
+		answered = challenge
+		return nil
+	})
+	if err != nil {
+		return LoginChallenge{}, err
+	}
+	return answered, answerErr
+}
+
+// deleteLoginChallenges drops all pending login challenges of a user
+func (dbs *DBStructure) deleteLoginChallenges(userid int) {
+	for hash, challenge := range dbs.LoginChallenges {
+		if challenge.UserID == userid {
+			delete(dbs.LoginChallenges, hash)
+		}
+	}
+}
+
+// LoginLocked reports whether logins with an email are refused and for how long
+func (db *DB) LoginLocked(email string) (time.Duration, bool, error) {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return 0, false, err
+	}
+	retryAfter, locked := DBStructure.LoginFailures[NormalizeEmail(email)].Locked(time.Now().UTC())
+	return retryAfter, locked, nil
+}
+
+// RecordLoginFailure counts a failed login of a user and forgets failures
+// older than the window. Failures with unknown emails are counted in the
+// memory of the LoginThrottle, so they can't fill the database.
+func (db *DB) RecordLoginFailure(id int) error {
+	return db.update(func(dbs *DBStructure) error {
+		user, exists := dbs.Users[id]
+		if !exists {
+			return ErrNotExist
+		}
+		now := time.Now().UTC()
+		for other, failures := range dbs.LoginFailures {
+			if now.Sub(failures.LastFailure) > loginFailureWindow {
+				delete(dbs.LoginFailures, other)
+			}
+		}
+		email := NormalizeEmail(user.Email)
+		dbs.LoginFailures[email] = dbs.LoginFailures[email].fail(accountFailureLimit, now)
+		return nil
+	})
+}
+
+// ClearLoginFailures forgets the failed logins with an email after a
+// successful login
+func (db *DB) ClearLoginFailures(email string) error {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	email = NormalizeEmail(email)
+	if _, exists := DBStructure.LoginFailures[email]; !exists {
+		return nil
+	}
+	delete(DBStructure.LoginFailures, email)
+	return db.writeDB(DBStructure)
+}
+
+// UnlockUser lifts the login lockout of a user
+func (db *DB) UnlockUser(id int, actor Actor) error {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	user, exists := DBStructure.Users[id]
+	if !exists {
+		return ErrNotExist
+	}
+	email := NormalizeEmail(user.Email)
+	failures := DBStructure.LoginFailures[email]
+	delete(DBStructure.LoginFailures, email)
+	DBStructure.appendAudit(actor, AuditEvent{
+		Action:     "user.unlock",
+		TargetType: "user",
+		TargetID:   id,
+		Details:    fmt.Sprintf("cleared %d failed logins", failures.Count),
+	})
+	return db.writeDB(DBStructure)
 }
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+const maxEmailLength = 254
+
+var ErrInvalidEmail = errors.New("invalid email address")
+
+// NormalizeEmail brings an email address into the form it is stored and
+// compared in: surrounding whitespace trimmed, NFKC normalized and case folded
+func NormalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	email = norm.NFKC.String(email)
+	return cases.Fold().String(email)
+}
+
+// ValidateEmail checks the syntax of a normalized email address. Display
+// names and comments are refused, the domain has to contain a dot.
+func ValidateEmail(email string) error {
+	if email == "" || len(email) > maxEmailLength {
+		return ErrInvalidEmail
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return ErrInvalidEmail
+	}
+	at := strings.LastIndex(email, "@")
+	domain := email[at+1:]
+	if at < 1 || !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return ErrInvalidEmail
+	}
+	return nil
+}
+
+// emailTaken reports whether another user than id already uses email
+func (dbs DBStructure) emailTaken(email string, id int) bool {
+	for _, user := range dbs.Users {
+		if user.ID != id && NormalizeEmail(user.Email) == email {
+			return true
+		}
+	}
+	return false
+}
+
+// EmailDuplicate is a group of accounts sharing one normalized email.
+// All accounts get merged into the oldest one, unless there is a conflict
+// an admin has to settle first.
+type EmailDuplicate struct {
+	Email     string `json:"email"`
+	KeepID    int    `json:"keep_id"`
+	MergedIDs []int  `json:"merged_ids"`
+	Conflict  string `json:"conflict,omitempty"`
+}
+
+// findEmailDuplicates groups the users by normalized email
+func (dbs DBStructure) findEmailDuplicates() []EmailDuplicate {
+	byEmail := map[string][]int{}
+	for _, user := range dbs.Users {
+		email := NormalizeEmail(user.Email)
+		byEmail[email] = append(byEmail[email], user.ID)
+	}
+	duplicates := []EmailDuplicate{}
+	for email, ids := range byEmail {
+		if len(ids) < 2 {
+			continue
+		}
+		sort.Ints(ids)
+		duplicate := EmailDuplicate{
+			Email:     email,
+			KeepID:    ids[0],
+			MergedIDs: ids[1:],
+		}
+		// merging must neither grant nor take away a role
+		role := dbs.Users[ids[0]].RoleOrDefault()
+		for _, id := range ids[1:] {
+			if dbs.Users[id].RoleOrDefault() != role {
+				duplicate.Conflict = "accounts have different roles"
+			}
+		}
+		duplicates = append(duplicates, duplicate)
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i].KeepID < duplicates[j].KeepID
+	})
+	return duplicates
+}
+
+// mergeUser moves everything owned by the user from into the user into
+// and deletes from. The login and the role of into are kept, of the
+// moderation states the strictest one. The audit trail stays untouched,
+// reports and moderation events are moved to into.
+func (dbs *DBStructure) mergeUser(into int, from int) {
+	keep := dbs.Users[into]
+	merged := dbs.Users[from]
+	now := time.Now().UTC()
+
+	for id, chirp := range dbs.Chirps {
+		if chirp.AuthorID == from {
+			chirp.AuthorID = into
+			dbs.Chirps[id] = chirp
+		}
+	}
+	if keep.PinnedChirpID == 0 {
+		keep.PinnedChirpID = merged.PinnedChirpID
+	}
+	keep.IsChirpyRed = keep.IsChirpyRed || merged.IsChirpyRed
+	keep.EmailVerified = keep.EmailVerified || merged.EmailVerified
+	if merged.IsSuspended(now) && (!keep.IsSuspended(now) || merged.SuspendedUntil == nil ||
+		keep.SuspendedUntil != nil && merged.SuspendedUntil.After(*keep.SuspendedUntil)) {
+		keep.Suspended = true
+		keep.SuspendedUntil = merged.SuspendedUntil
+	}
+	keep.HideChirps = keep.HideChirps || merged.HideChirps && merged.IsSuspended(now)
+	keep.Shadowbanned = keep.Shadowbanned || merged.Shadowbanned
+	// the filters of into keep their ids, merged filters get new ones
+	for _, filter := range keep.KeywordFilters {
+		keep.LastFilterID = max(keep.LastFilterID, filter.ID)
+	}
+	for _, filter := range merged.KeywordFilters {
+		keep.LastFilterID++
+		filter.ID = keep.LastFilterID
+		keep.KeywordFilters = append(keep.KeywordFilters, filter)
+	}
+
+	for _, chirpid := range dbs.Bookmarks[from] {
+		if !contains(dbs.Bookmarks[into], chirpid) {
+			dbs.Bookmarks[into] = append(dbs.Bookmarks[into], chirpid)
+		}
+	}
+	delete(dbs.Bookmarks, from)
+
+	for _, lists := range []map[int][]int{dbs.Follows, dbs.Mutes, dbs.Blocks} {
+		for _, id := range lists[from] {
+			if id != into && !contains(lists[into], id) {
+				lists[into] = append(lists[into], id)
+			}
+		}
+		delete(lists, from)
+		// lists of other users pointing at the merged user
+		for owner, ids := range lists {
+			if !contains(ids, from) {
+				continue
+			}
+			ids = removeID(ids, from)
+			if owner != into && !contains(ids, into) {
+				ids = append(ids, into)
+			}
+			lists[owner] = ids
+		}
+	}
+	for hash, verification := range dbs.EmailVerifications {
+		if verification.UserID == from {
+			delete(dbs.EmailVerifications, hash)
+		}
+	}
+	for hash, reset := range dbs.PasswordResets {
+		if reset.UserID == from {
+			delete(dbs.PasswordResets, hash)
+		}
+	}
+	for i, report := range dbs.Reports {
+		if report.ReporterID == from {
+			report.ReporterID = into
+		}
+		if report.ResolvedBy == from {
+			report.ResolvedBy = into
+		}
+		dbs.Reports[i] = report
+	}
+	for i, event := range dbs.ModerationEvents {
+		if event.AuthorID == from {
+			event.AuthorID = into
+		}
+		if event.ResolvedBy == from {
+			event.ResolvedBy = into
+		}
+		dbs.ModerationEvents[i] = event
+	}
+	dbs.deleteSessions(from, 0)
+	delete(dbs.TwoFactor, from)
+	dbs.Users[into] = keep
+	delete(dbs.Users, from)
+}
+
+// RepairEmails finds accounts sharing a normalized email, merges them into
+// the oldest account and stores all emails normalized. With dryRun the
+// duplicates are only reported.
+func (db *DB) RepairEmails(dryRun bool) ([]EmailDuplicate, error) {
+	DBStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	duplicates := DBStructure.findEmailDuplicates()
+	if dryRun {
+		return duplicates, nil
+	}
+
+	actor := Actor{Type: ActorSystem}
+	skipped := map[int]bool{}
+	for _, duplicate := range duplicates {
+		if duplicate.Conflict != "" {
+			skipped[duplicate.KeepID] = true
+			for _, id := range duplicate.MergedIDs {
+				skipped[id] = true
+			}
+			continue
+		}
+		for _, from := range duplicate.MergedIDs {
+			DBStructure.mergeUser(duplicate.KeepID, from)
+			DBStructure.appendAudit(actor, AuditEvent{
+				Action:     "user.merge",
+				TargetType: "user",
+				TargetID:   duplicate.KeepID,
+				Details:    fmt.Sprintf("merged user %d with the same email", from),
+			})
+		}
+	}
+	// accounts left unmerged keep their email, normalized they would clash
+	for id, user := range DBStructure.Users {
+		if skipped[id] {
+			continue
+		}
+		user.Email = NormalizeEmail(user.Email)
+		DBStructure.Users[id] = user
+	}
+	return duplicates, db.writeDB(DBStructure)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestDBStructure returns an empty database with the given users
+func newTestDBStructure(users ...User) *DBStructure {
+	dbs := &DBStructure{}
+	dbs.ensureMaps()
+	for _, user := range users {
+		dbs.Users[user.ID] = user
+	}
+	return dbs
+}
+
+func TestMergeUserKeepsStrictestModeration(t *testing.T) {
+	until := time.Now().UTC().Add(time.Hour)
+	dbs := newTestDBStructure(
+		User{ID: 1, Email: "a@example.com", Suspended: true, SuspendedUntil: &until},
+		User{ID: 2, Email: "A@example.com", Suspended: true, HideChirps: true, Shadowbanned: true},
+	)
+
+	dbs.mergeUser(1, 2)
+
+	keep := dbs.Users[1]
+	if !keep.Suspended || keep.SuspendedUntil != nil {
+		t.Errorf("want the permanent suspension of the merged user, got suspended=%v until=%v", keep.Suspended, keep.SuspendedUntil)
+	}
+	if !keep.HideChirps || !keep.Shadowbanned {
+		t.Errorf("want hide_chirps and shadowbanned carried over, got %v and %v", keep.HideChirps, keep.Shadowbanned)
+	}
+	if _, exists := dbs.Users[2]; exists {
+		t.Error("merged user still exists")
+	}
+}
+
+func TestMergeUserKeepsLongerSuspension(t *testing.T) {
+	now := time.Now().UTC()
+	sooner, later := now.Add(time.Hour), now.Add(48*time.Hour)
+	dbs := newTestDBStructure(
+		User{ID: 1, Suspended: true, SuspendedUntil: &later},
+		User{ID: 2, Suspended: true, SuspendedUntil: &sooner, HideChirps: true},
+	)
+
+	dbs.mergeUser(1, 2)
+
+	keep := dbs.Users[1]
+	if keep.SuspendedUntil == nil || !keep.SuspendedUntil.Equal(later) {
+		t.Errorf("want suspension until %v, got %v", later, keep.SuspendedUntil)
+	}
+	if !keep.HideChirps {
+		t.Error("want hide_chirps of the active suspension carried over")
+	}
+}
+
+func TestMergeUserKeepsRole(t *testing.T) {
+	dbs := newTestDBStructure(
+		User{ID: 1},
+		User{ID: 2, Role: RoleAdmin},
+	)
+
+	dbs.mergeUser(1, 2)
+
+	if role := dbs.Users[1].RoleOrDefault(); role != RoleUser {
+		t.Errorf("want role %s, got %s", RoleUser, role)
+	}
+}
+
+func TestMergeUserMovesReportsAndModerationEvents(t *testing.T) {
+	dbs := newTestDBStructure(User{ID: 1}, User{ID: 2}, User{ID: 3})
+	dbs.Reports = []Report{
+		{ID: 1, ChirpID: 5, ReporterID: 2, Status: ReportResolved, ResolvedBy: 2},
+		{ID: 2, ChirpID: 6, ReporterID: 3, Status: ReportOpen},
+	}
+	dbs.ModerationEvents = []ModerationEvent{
+		{ID: 1, ChirpID: 7, AuthorID: 2, Status: ModerationPending},
+		{ID: 2, ChirpID: 8, AuthorID: 3},
+	}
+
+	dbs.mergeUser(1, 2)
+
+	if report := dbs.Reports[0]; report.ReporterID != 1 || report.ResolvedBy != 1 {
+		t.Errorf("want report moved to user 1, got reporter %d resolved by %d", report.ReporterID, report.ResolvedBy)
+	}
+	if report := dbs.Reports[1]; report.ReporterID != 3 {
+		t.Errorf("want report of user 3 untouched, got reporter %d", report.ReporterID)
+	}
+	if event := dbs.ModerationEvents[0]; event.AuthorID != 1 {
+		t.Errorf("want moderation event moved to user 1, got author %d", event.AuthorID)
+	}
+	if event := dbs.ModerationEvents[1]; event.AuthorID != 3 {
+		t.Errorf("want moderation event of user 3 untouched, got author %d", event.AuthorID)
+	}
+}
+
+func TestMergeUserKeepsFilterIDs(t *testing.T) {
+	dbs := newTestDBStructure(
+		User{ID: 1, KeywordFilters: []KeywordFilter{{ID: 3, Phrase: "a"}}, LastFilterID: 4},
+		User{ID: 2, KeywordFilters: []KeywordFilter{{ID: 1, Phrase: "b"}}},
+	)
+
+	dbs.mergeUser(1, 2)
+
+	keep := dbs.Users[1]
+	if len(keep.KeywordFilters) != 2 || keep.KeywordFilters[0].ID != 3 || keep.KeywordFilters[1].ID != 5 {
+		t.Errorf("want filter ids 3 and 5, got %+v", keep.KeywordFilters)
+	}
+	if keep.LastFilterID != 5 {
+		t.Errorf("want last filter id 5, got %d", keep.LastFilterID)
+	}
+}
+
+func TestFindEmailDuplicatesRoleConflict(t *testing.T) {
+	dbs := newTestDBStructure(
+		User{ID: 1, Email: "a@example.com"},
+		User{ID: 2, Email: "A@example.com", Role: RoleModerator},
+		User{ID: 3, Email: "b@example.com"},
+		User{ID: 4, Email: " B@example.com"},
+	)
+
+	duplicates := dbs.findEmailDuplicates()
+
+	if len(duplicates) != 2 {
+		t.Fatalf("want 2 duplicates, got %+v", duplicates)
+	}
+	if duplicates[0].KeepID != 1 || duplicates[0].Conflict == "" {
+		t.Errorf("want a role conflict for user 1, got %+v", duplicates[0])
+	}
+	if duplicates[1].KeepID != 3 || duplicates[1].Conflict != "" {
+		t.Errorf("want users 3 and 4 merged without conflict, got %+v", duplicates[1])
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const maxKeywordFilters = 50
+const maxKeywordFilterLength = 100
+
+var ErrTooManyFilters = errors.New("too many keyword filters")
+
+// KeywordFilter hides chirps containing a phrase from the timeline of the
+// user who created it
+type KeywordFilter struct {
+	ID     int    `json:"id"`
+	Phrase string `json:"phrase"`
+	// WholeWord only matches the phrase when it is not part of a longer word
+	WholeWord bool `json:"whole_word,omitempty"`
+	// Regex treats the phrase as a regular expression
+	Regex     bool       `json:"regex,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type filterparameters struct {
+	Phrase    string `json:"phrase"`
+	WholeWord bool   `json:"whole_word,omitempty"`
+	Regex     bool   `json:"regex,omitempty"`
+	ExpiresIn int    `json:"expires_in,omitempty"`
+}
+
+// Expired reports whether the filter has passed its expiry time
+func (f KeywordFilter) Expired(now time.Time) bool {
+	return f.ExpiresAt != nil && !f.ExpiresAt.After(now)
+}
+
+// compile turns the filter into a case insensitive regular expression
+func (f KeywordFilter) compile() (*regexp.Regexp, error) {
+	pattern := regexp.QuoteMeta(f.Phrase)
+	if f.Regex {
+		pattern = f.Phrase
+	}
+	if f.WholeWord {
+		// \b only knows ASCII word characters
+		pattern = `(?:^|[^\p{L}\p{N}_])(?:` + pattern + `)(?:$|[^\p{L}\p{N}_])`
+	}
+	return regexp.Compile(`(?i)` + pattern)
+}
+
+// keywordMatcher compiles the active filters of a user. Filters that fail
+// to compile were rejected on creation and are skipped.
+func keywordMatcher(filters []KeywordFilter, now time.Time) func(Chirp) bool {
+	matchers := []*regexp.Regexp{}
+	for _, filter := range filters {
+		if filter.Expired(now) {
+			continue
+		}
+		re, err := filter.compile()
+		if err != nil {
+			continue
+		}
+		matchers = append(matchers, re)
+	}
+	return func(chirp Chirp) bool {
+		for _, re := range matchers {
+			if re.MatchString(chirp.Body) || re.MatchString(chirp.ContentWarning) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// lists the active keyword filters of the authenticated user
+func (cfg *apiConfig) GetFilters(w http.ResponseWriter, req *http.Request) {
+	userid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	user, err := cfg.DB.GetUserbyID(userid)
+	if err != nil {
+		respondWithError(w, 500, "cannot get user by id")
+		return
+	}
+	now := time.Now().UTC()
+	filters := []KeywordFilter{}
+	for _, filter := range user.KeywordFilters {
+		if !filter.Expired(now) {
+			filters = append(filters, filter)
+		}
+	}
+	respondWithJSON(w, 200, filters)
+}
+
+// adds a keyword filter for the authenticated user
+func (cfg *apiConfig) PostFilter(w http.ResponseWriter, req *http.Request) {
+	userid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	decoder := json.NewDecoder(req.Body)
+	params := filterparameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "cannot decode json")
+		return
+	}
+	if params.Phrase == "" || len(params.Phrase) > maxKeywordFilterLength {
+		respondWithError(w, 400, "phrase must be between 1 and 100 characters")
+		return
+	}
+	if params.ExpiresIn < 0 {
+		respondWithError(w, 400, "expires_in must not be negative")
+		return
+	}
+	if params.ExpiresIn > maxExpiresIn {
+		respondWithError(w, 400, fmt.Sprintf("expires_in must not be more than %d seconds", maxExpiresIn))
+		return
+	}
+	filter := KeywordFilter{
+		Phrase:    params.Phrase,
+		WholeWord: params.WholeWord,
+		Regex:     params.Regex,
+	}
+	if params.ExpiresIn > 0 {
+		expiresAt := time.Now().UTC().Add(time.Duration(params.ExpiresIn) * time.Second)
+		filter.ExpiresAt = &expiresAt
+	}
+	if _, err := filter.compile(); err != nil {
+		respondWithError(w, 400, "invalid regex: "+err.Error())
+		return
+	}
+
+	filter, err = cfg.DB.AddKeywordFilter(userid, filter)
+	if errors.Is(err, ErrTooManyFilters) {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot add keyword filter: %s\n", err.Error())
+		respondWithError(w, 500, "cannot add keyword filter")
+		return
+	}
+	respondWithJSON(w, 201, filter)
+}
+
+// deletes a keyword filter of the authenticated user
+func (cfg *apiConfig) DelFilter(w http.ResponseWriter, req *http.Request) {
+	userid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	sid := req.PathValue("filterID")
+	filterid, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "filter id could not be parsed")
+		return
+	}
+
+	err = cfg.DB.DelKeywordFilter(userid, filterid)
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, 404, "Filter does not exist")
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot delete keyword filter: %s\n", err.Error())
+		respondWithError(w, 500, "cannot delete keyword filter")
+		return
+	}
+	respondWithJSON(w, 200, "Filter deleted")
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPostFilterExpiresIn(t *testing.T) {
+	cfg := newTestConfig(t)
+	createTestUser(t, cfg, "a@example.com")
+	login := loginTestUser(t, cfg, "a@example.com", 200)
+
+	tests := []struct {
+		expiresIn int
+		want      int
+	}{
+		{-1, 400},
+		{maxExpiresIn + 1, 400},
+		// would overflow time.Duration and expire in the past
+		{1 << 62, 400},
+		{maxExpiresIn, 201},
+	}
+	for _, tt := range tests {
+		w := testRequest(cfg.PostFilter, "POST", login.Token, filterparameters{Phrase: "a", ExpiresIn: tt.expiresIn})
+		if w.Code != tt.want {
+			t.Errorf("expires_in %d: want status %d, got %d: %s", tt.expiresIn, tt.want, w.Code, w.Body.String())
+		}
+		if w.Code != 201 {
+			continue
+		}
+		filter := KeywordFilter{}
+		decodeResponse(t, w, &filter)
+		if filter.ExpiresAt == nil || filter.ExpiresAt.Before(time.Now().UTC().Add(364*24*time.Hour)) {
+			t.Errorf("expires_in %d: want an expiry in a year, got %v", tt.expiresIn, filter.ExpiresAt)
+		}
+	}
+}
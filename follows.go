@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// the authenticated user starts following another user
+func (cfg *apiConfig) PostFollow(w http.ResponseWriter, req *http.Request) {
+	userid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	if !cfg.requireVerified(w, userid, RestrictFollows) {
+		return
+	}
+	sid := req.PathValue("userID")
+	followee, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "user id could not be parsed")
+		return
+	}
+	if followee == userid {
+		respondWithError(w, 400, "cannot follow yourself")
+		return
+	}
+
+	err = cfg.DB.Follow(userid, followee)
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, 404, "User does not exist")
+		return
+	}
+	if errors.Is(err, ErrBlocked) {
+		respondWithError(w, 403, "Forbidden - blocked by user")
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot follow user: %s\n", err.Error())
+		respondWithError(w, 500, "cannot follow user")
+		return
+	}
+	respondWithJSON(w, 200, "User followed")
+}
+
+// the authenticated user stops following another user
+func (cfg *apiConfig) DelFollow(w http.ResponseWriter, req *http.Request) {
+	userid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	sid := req.PathValue("userID")
+	followee, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "user id could not be parsed")
+		return
+	}
+
+	err = cfg.DB.Unfollow(userid, followee)
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, 404, "User is not followed")
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot unfollow user: %s\n", err.Error())
+		respondWithError(w, 500, "cannot unfollow user")
+		return
+	}
+	respondWithJSON(w, 200, "User unfollowed")
+}
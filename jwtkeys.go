@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const defaultJWTKeysDir = "./keys"
+
+// Algorithms of the signing keys
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+)
+
+const rsaKeyBits = 3072
+
+// JWTKey is a key tokens are signed or verified with. Private is nil for
+// retired keys that only verify tokens issued before a rotation.
+type JWTKey struct {
+	ID      string
+	Alg     string
+	Public  crypto.PublicKey
+	Private crypto.Signer
+}
+
+func (k JWTKey) method() jwt.SigningMethod {
+	if k.Alg == AlgRS256 {
+		return jwt.SigningMethodRS256
+	}
+	return jwt.SigningMethodEdDSA
+}
+
+// KeySet holds the key new tokens are signed with and every key tokens are
+// accepted from. Without asymmetric keys tokens are signed with the shared
+// HS256 secret like before. With keys the secret only verifies tokens that
+// expire before secretUntil, and none once it passed or when it is zero.
+type KeySet struct {
+	signing     *JWTKey
+	keys        map[string]JWTKey
+	secret      []byte
+	secretUntil time.Time
+}
+
+// LoadKeySet reads the PEM files of a directory. Private keys (PKCS #8) can
+// sign and verify, public keys (PKIX) only verify. The file name without
+// extension is the key id. The key named signingID signs new tokens, by
+// default the private key with the last name in sort order, so rotating
+// means adding a key with a later name and keeping the old one until its
+// tokens expired. secretUntil is when tokens signed with the secret stop
+// being accepted once there are keys, set it to the time of the switch plus
+// the lifetime of an access token. It has to be configured rather than
+// derived from the start, a restart would extend it otherwise.
+func LoadKeySet(dir string, signingID string, secret string, secretUntil time.Time) (*KeySet, error) {
+	set := KeySet{keys: map[string]JWTKey{}, secret: []byte(secret), secretUntil: secretUntil}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		key, err := loadJWTKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load %s: %w", path, err)
+		}
+		set.keys[key.ID] = key
+		if key.Private != nil && signingID == "" {
+			set.signing = &key
+		}
+	}
+	if signingID != "" {
+		key, exists := set.keys[signingID]
+		if !exists || key.Private == nil {
+			return nil, fmt.Errorf("no private key %s in %s", signingID, dir)
+		}
+		set.signing = &key
+	}
+	if set.signing == nil && len(set.secret) == 0 {
+		return nil, errors.New("neither a signing key nor JWT_SECRET is configured")
+	}
+	return &set, nil
+}
+
+func loadJWTKey(path string) (JWTKey, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return JWTKey{}, err
+	}
+	block, _ := pem.Decode(dat)
+	if block == nil {
+		return JWTKey{}, errors.New("no PEM data")
+	}
+	key := JWTKey{ID: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))}
+
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return JWTKey{}, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return JWTKey{}, err
+	}
+	switch k := parsed.(type) {
+	case ed25519.PrivateKey:
+		key.Alg, key.Private, key.Public = AlgEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Alg, key.Public = AlgEdDSA, k
+	case *rsa.PrivateKey:
+		key.Alg, key.Private, key.Public = AlgRS256, k, k.Public()
+	case *rsa.PublicKey:
+		key.Alg, key.Public = AlgRS256, k
+	default:
+		return JWTKey{}, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	return key, nil
+}
+
+// GenerateJWTKey writes a new private key named by the current time to the
+// directory and returns its id
+func GenerateJWTKey(dir string, alg string) (string, error) {
+	var private any
+	var err error
+	switch alg {
+	case AlgEdDSA, "ed25519":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRS256, "rsa":
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		return "", fmt.Errorf("unknown algorithm %q, use ed25519 or rsa", alg)
+	}
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	id := time.Now().UTC().Format("20060102T150405Z")
+	f, err := os.OpenFile(filepath.Join(dir, id+".pem"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return id, pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// Sign signs claims with the signing key and names it in the kid header
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if s.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	}
+	token := jwt.NewWithClaims(s.signing.method(), claims)
+	token.Header["kid"] = s.signing.ID
+	return token.SignedString(s.signing.Private)
+}
+
+// keyfunc picks the verification key by the kid header. Tokens without kid
+// were signed with the shared secret, once signing keys are configured they
+// have to expire before secretUntil. A token forged with a leaked secret is
+// then worthless after that time, however often the server restarts.
+func (s *KeySet) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if len(s.secret) == 0 || token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("token has no kid")
+		}
+		if s.signing != nil {
+			expiresAt, err := token.Claims.GetExpirationTime()
+			if err != nil || expiresAt == nil || !time.Now().UTC().Before(s.secretUntil) || expiresAt.After(s.secretUntil) {
+				return nil, errors.New("tokens signed with JWT_SECRET are no longer accepted")
+			}
+		}
+		return s.secret, nil
+	}
+	key, exists := s.keys[kid]
+	if !exists {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method != key.method() {
+		return nil, fmt.Errorf("kid %q does not sign with %s", kid, token.Method.Alg())
+	}
+	return key.Public, nil
+}
+
+// JWK is a public key in the JSON Web Key format of RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// JWKS returns the public keys of all keys in the set
+func (s *KeySet) JWKS() []JWK {
+	keys := []JWK{}
+	for _, key := range s.keys {
+		jwk := JWK{Kid: key.ID, Alg: key.Alg, Use: "sig"}
+		switch public := key.Public.(type) {
+		case ed25519.PublicKey:
+			jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		}
+		keys = append(keys, jwk)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Kid < keys[j].Kid
+	})
+	return keys
+}
+
+// publishes the public keys so other services can verify access tokens
+func (cfg *apiConfig) GetJWKS(w http.ResponseWriter, req *http.Request) {
+	type response struct {
+		Keys []JWK `json:"keys"`
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, 200, response{Keys: cfg.Keys.JWKS()})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newTestKeySet returns a key set with a generated ed25519 signing key and
+// the shared secret of older tokens, accepted until secretUntil
+func newTestKeySet(t *testing.T, secretUntil time.Time) *KeySet {
+	t.Helper()
+	dir := t.TempDir()
+	_, err := GenerateJWTKey(dir, AlgEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadKeySet(dir, "", "test secret", secretUntil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// testClaims returns valid claims of user 1 expiring after expiresIn
+func testClaims(expiresIn time.Duration) ChirpyClaims {
+	return ChirpyClaims{RegisteredClaims: jwt.RegisteredClaims{
+		Issuer:    "chirpy",
+		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+		Subject:   "1",
+	}}
+}
+
+// signWithSecret signs claims with HS256 and no kid like before signing keys
+func signWithSecret(t *testing.T, secret string, expiresIn time.Duration) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims(expiresIn)).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestSignedTokensAreValid(t *testing.T) {
+	keys := newTestKeySet(t, time.Time{})
+	token, err := MakeJWT(1, 1, RoleUser, keys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(token, keys); err != nil {
+		t.Errorf("want the token valid, got %s", err)
+	}
+}
+
+func TestSecretTokensAreRefusedWithKeys(t *testing.T) {
+	dir := t.TempDir()
+	_, err := GenerateJWTKey(dir, AlgEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every start refuses a token freshly signed with the old secret
+	for i := 0; i < 2; i++ {
+		keys, err := LoadKeySet(dir, "", "test secret", time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ValidateJWT(signWithSecret(t, "test secret", time.Minute), keys); err == nil {
+			t.Fatalf("start %d: want the secret token refused", i+1)
+		}
+	}
+}
+
+func TestSecretTokensAreAcceptedUntilDeadline(t *testing.T) {
+	keys := newTestKeySet(t, time.Now().UTC().Add(time.Hour))
+
+	if _, err := ValidateJWT(signWithSecret(t, "test secret", time.Minute), keys); err != nil {
+		t.Errorf("want a token expiring before the deadline valid, got %s", err)
+	}
+	if _, err := ValidateJWT(signWithSecret(t, "test secret", 2*time.Hour), keys); err == nil {
+		t.Error("want a token expiring after the deadline refused")
+	}
+
+	keys = newTestKeySet(t, time.Now().UTC().Add(-time.Second))
+	if _, err := ValidateJWT(signWithSecret(t, "test secret", time.Minute), keys); err == nil {
+		t.Error("want the token refused after the deadline")
+	}
+}
+
+func TestSecretSignsWithoutKeys(t *testing.T) {
+	keys, err := LoadKeySet(t.TempDir(), "", "test secret", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the secret is the only key, there is nothing to switch to
+	if _, err := ValidateJWT(signWithSecret(t, "test secret", time.Hour), keys); err != nil {
+		t.Errorf("want the token valid, got %s", err)
+	}
+}
+
+func TestKeyfuncRefusesTokens(t *testing.T) {
+	keys := newTestKeySet(t, time.Now().UTC().Add(time.Hour))
+
+	wrongSecret := signWithSecret(t, "other secret", time.Minute)
+
+	unknownKid := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims(time.Minute))
+	unknownKid.Header["kid"] = "unknown"
+	unknownKidToken, err := unknownKid.SignedString([]byte("test secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// HS256 with the id of the ed25519 key and its public key as secret
+	wrongAlg := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims(time.Minute))
+	wrongAlg.Header["kid"] = keys.signing.ID
+	wrongAlgToken, err := wrongAlg.SignedString([]byte(keys.signing.Public.(ed25519.PublicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"wrong secret": wrongSecret,
+		"unknown kid":  unknownKidToken,
+		"wrong alg":    wrongAlgToken,
+		"not a token":  "not a token",
+		"empty token":  "",
+	}
+	for name, token := range tests {
+		if _, err := ValidateJWT(token, keys); err == nil {
+			t.Errorf("%s: want the token refused", name)
+		}
+	}
+}
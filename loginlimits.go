@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// failed logins allowed before the backoff starts
+const (
+	accountFailureLimit = 5
+	ipFailureLimit      = 20
+)
+
+const (
+	// first lockout after the limit, it doubles with every further failure
+	loginBaseLockout = time.Minute
+	loginMaxLockout  = time.Hour
+	// failures older than this are forgotten
+	loginFailureWindow = 24 * time.Hour
+	// unknown emails tracked in memory, the oldest are forgotten first
+	maxThrottledEmails = 10000
+)
+
+var ErrLoginLocked = errors.New("too many failed logins")
+
+// compared against when a login names an unknown email, so it takes as
+// long as a login with a wrong password
+var dummyPasswordHash, _ = HashPassword("chirpy has no user with this email")
+
+// LoginFailures counts the failed logins of an account or an address
+type LoginFailures struct {
+	Count       int       `json:"count"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until,omitempty"`
+}
+
+// fail records a failed login and locks once the limit is reached, the
+// lockout doubles with every failure after that
+func (f LoginFailures) fail(limit int, now time.Time) LoginFailures {
+	if now.Sub(f.LastFailure) > loginFailureWindow {
+		f = LoginFailures{}
+	}
+	f.Count++
+	f.LastFailure = now
+	if f.Count >= limit {
+		lockout := loginBaseLockout * time.Duration(math.Pow(2, float64(f.Count-limit)))
+		if lockout > loginMaxLockout || lockout <= 0 {
+			lockout = loginMaxLockout
+		}
+		f.LockedUntil = now.Add(lockout)
+	}
+	return f
+}
+
+// Locked returns how long logins stay refused
+func (f LoginFailures) Locked(now time.Time) (time.Duration, bool) {
+	if f.LockedUntil.After(now) {
+		return f.LockedUntil.Sub(now), true
+	}
+	return 0, false
+}
+
+// LoginThrottle tracks failed logins per address and per unknown email in
+// memory. Failed logins of existing accounts are stored in the database so
+// a restart doesn't reset them. Unknown emails lock the same way, so the
+// lockout doesn't tell which emails have an account.
+type LoginThrottle struct {
+	mux    *sync.Mutex
+	ips    map[string]LoginFailures
+	emails map[string]LoginFailures
+}
+
+func NewLoginThrottle() *LoginThrottle {
+	return &LoginThrottle{
+		mux:    &sync.Mutex{},
+		ips:    map[string]LoginFailures{},
+		emails: map[string]LoginFailures{},
+	}
+}
+
+// Locked reports whether logins from an address are refused
+func (t *LoginThrottle) Locked(ip string, now time.Time) (time.Duration, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.ips[ip].Locked(now)
+}
+
+// Fail records a failed login from an address
+func (t *LoginThrottle) Fail(ip string, now time.Time) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	forgetLoginFailures(t.ips, now)
+	t.ips[ip] = t.ips[ip].fail(ipFailureLimit, now)
+}
+
+// EmailLocked reports whether logins with an unknown email are refused
+func (t *LoginThrottle) EmailLocked(email string, now time.Time) (time.Duration, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.emails[NormalizeEmail(email)].Locked(now)
+}
+
+// FailEmail records a failed login with an email no account has, with the
+// limit of accounts
+func (t *LoginThrottle) FailEmail(email string, now time.Time) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	forgetLoginFailures(t.emails, now)
+	email = NormalizeEmail(email)
+	if _, exists := t.emails[email]; !exists && len(t.emails) >= maxThrottledEmails {
+		oldest := ""
+		for other, failures := range t.emails {
+			if oldest == "" || failures.LastFailure.Before(t.emails[oldest].LastFailure) {
+				oldest = other
+			}
+		}
+		delete(t.emails, oldest)
+	}
+	t.emails[email] = t.emails[email].fail(accountFailureLimit, now)
+}
+
+// forgetLoginFailures drops the failures older than the window
+func forgetLoginFailures(failures map[string]LoginFailures, now time.Time) {
+	for key, f := range failures {
+		if now.Sub(f.LastFailure) > loginFailureWindow {
+			delete(failures, key)
+		}
+	}
+}
+
+// respondLocked refuses a login the same way for locked accounts and
+// locked addresses
+func respondLocked(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	respondWithError(w, 429, "Too many failed logins, try again later")
+}
+
+// respondUserLocked refuses a login of a locked account with the time
+// until the lock ends
+func (cfg *apiConfig) respondUserLocked(w http.ResponseWriter, userid int) {
+	retryAfter := loginMaxLockout
+	user, err := cfg.DB.GetUserbyID(userid)
+	if err == nil {
+		if locked, isLocked, err := cfg.DB.LoginLocked(user.Email); err == nil && isLocked {
+			retryAfter = locked
+		}
+	}
+	respondLocked(w, retryAfter)
+}
+
+// respondLoginFailed is the answer to every wrong email or password
+func respondLoginFailed(w http.ResponseWriter) {
+	respondWithError(w, 401, "Unauthorized - incorrect email or password")
+}
+
+// lifts the login lockout of a user and forgets their failed logins
+func (cfg *apiConfig) PostUnlockUser(w http.ResponseWriter, req *http.Request) {
+	actorid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	sid := req.PathValue("userID")
+	userid, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "user id could not be parsed")
+		return
+	}
+
+	err = cfg.DB.UnlockUser(userid, userActor(req, actorid))
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, 404, "User does not exist")
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot unlock user: %s\n", err.Error())
+		respondWithError(w, 500, "cannot unlock user")
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// failTestLogin logs in with a wrong password and wants the status want
+func failTestLogin(t *testing.T, cfg *apiConfig, email string, want int) {
+	t.Helper()
+	w := testRequest(cfg.PostLogin, "POST", "", userparameters{Email: email, Password: "wrong password"})
+	if w.Code != want {
+		t.Fatalf("login: want status %d, got %d: %s", want, w.Code, w.Body.String())
+	}
+}
+
+func TestWrongPasswordsLockAccount(t *testing.T) {
+	cfg := newTestConfig(t)
+	createTestUser(t, cfg, "a@example.com")
+
+	for i := 0; i < accountFailureLimit; i++ {
+		failTestLogin(t, cfg, "a@example.com", 401)
+	}
+
+	// the right password is refused too while the account is locked
+	w := testRequest(cfg.PostLogin, "POST", "", userparameters{Email: "A@example.com", Password: testPassword})
+	if w.Code != 429 {
+		t.Fatalf("want status 429, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("want a Retry-After header")
+	}
+}
+
+func TestSuccessfulLoginClearsFailures(t *testing.T) {
+	cfg := newTestConfig(t)
+	createTestUser(t, cfg, "a@example.com")
+
+	for i := 0; i < accountFailureLimit-1; i++ {
+		failTestLogin(t, cfg, "a@example.com", 401)
+	}
+	loginTestUser(t, cfg, "a@example.com", 200)
+
+	failTestLogin(t, cfg, "a@example.com", 401)
+	loginTestUser(t, cfg, "a@example.com", 200)
+}
+
+func TestPasswordWithoutSecondFactorKeepsFailures(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := createTestUser(t, cfg, "a@example.com")
+	secret := enableTestTwoFactor(t, cfg, user)
+
+	for i := 0; i < accountFailureLimit-1; i++ {
+		failTestLogin(t, cfg, "a@example.com", 401)
+	}
+	// the right password alone doesn't reset the count, the wrong code
+	// that follows locks the account
+	login := loginTestUser(t, cfg, "a@example.com", 200)
+	answerTestChallenge(t, cfg, login.ChallengeToken, wrongTOTPCode(secret), 401)
+
+	loginTestUser(t, cfg, "a@example.com", 429)
+}
+
+func TestUnknownEmailsLockLikeAccounts(t *testing.T) {
+	cfg := newTestConfig(t)
+	createTestUser(t, cfg, "a@example.com")
+
+	codes := map[string][]int{}
+	for _, email := range []string{"a@example.com", "nobody@example.com"} {
+		for i := 0; i < accountFailureLimit+2; i++ {
+			w := testRequest(cfg.PostLogin, "POST", "", userparameters{Email: email, Password: "wrong password"})
+			codes[email] = append(codes[email], w.Code)
+		}
+	}
+
+	if fmt.Sprint(codes["a@example.com"]) != fmt.Sprint(codes["nobody@example.com"]) {
+		t.Errorf("want the same responses, got %v for an account and %v for an unknown email", codes["a@example.com"], codes["nobody@example.com"])
+	}
+	if last := codes["nobody@example.com"][accountFailureLimit]; last != 429 {
+		t.Errorf("want the unknown email locked, got %d", last)
+	}
+	dbs, err := cfg.DB.loadDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := dbs.LoginFailures["nobody@example.com"]; exists {
+		t.Error("want failures of unknown emails kept out of the database")
+	}
+}
+
+func TestUnknownEmailsAreThrottledPerAddress(t *testing.T) {
+	cfg := newTestConfig(t)
+
+	for i := 0; i < ipFailureLimit; i++ {
+		failTestLogin(t, cfg, fmt.Sprintf("nobody%d@example.com", i), 401)
+	}
+
+	failTestLogin(t, cfg, "someone@example.com", 429)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails to users
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// SMTPMailer sends emails through an SMTP server, authenticating with
+// PLAIN auth when a username is set
+type SMTPMailer struct {
+	// Addr is the host:port of the server
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, formatMessage(m.From, msg))
+}
+
+// FileMailer appends every email to a file instead of sending it, for
+// development and offline tests
+type FileMailer struct {
+	Path string
+	From string
+	mux  *sync.Mutex
+}
+
+func NewFileMailer(path string, from string) *FileMailer {
+	return &FileMailer{Path: path, From: from, mux: &sync.Mutex{}}
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(formatMessage(m.From, msg), '\n'))
+	return err
+}
+
+// LogMailer writes every email to the log, it is used when no mail
+// transport is configured. Bodies carry verification and reset tokens, so
+// they are only logged with ShowBody, for development.
+type LogMailer struct {
+	ShowBody bool
+}
+
+func (m LogMailer) Send(msg Message) error {
+	if !m.ShowBody {
+		log.Printf("mail to %s: %s (body not logged, set MAIL_LOG_BODY=true to show it)\n", msg.To, msg.Subject)
+		return nil
+	}
+	log.Printf("mail to %s: %s\n%s\n", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// mailDelivered reports whether mails reach anyone, the log only carries
+// the bodies with ShowBody
+func mailDelivered(m Mailer) bool {
+	logMailer, ok := m.(LogMailer)
+	return !ok || logMailer.ShowBody
+}
+
+// formatMessage renders a message with the headers a mail server expects
+func formatMessage(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// NewMailerFromEnv picks the mailer from the environment: SMTP when
+// MAIL_SMTP_ADDR is set, a file when MAIL_FILE is set and the log otherwise.
+// The log only shows the bodies with MAIL_LOG_BODY=true.
+func NewMailerFromEnv() Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "chirpy@localhost"
+	}
+	if addr := os.Getenv("MAIL_SMTP_ADDR"); addr != "" {
+		return SMTPMailer{
+			Addr:     addr,
+			Username: os.Getenv("MAIL_SMTP_USER"),
+			Password: os.Getenv("MAIL_SMTP_PASSWORD"),
+			From:     from,
+		}
+	}
+	if path := os.Getenv("MAIL_FILE"); path != "" {
+		return NewFileMailer(path, from)
+	}
+	if os.Getenv("MAIL_LOG_BODY") == "true" {
+		log.Println("MAIL_LOG_BODY is set, mails including their tokens are written to the log")
+		return LogMailer{ShowBody: true}
+	}
+	log.Println("no mail transport configured, mails are not delivered")
+	return LogMailer{}
+}
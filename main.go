@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -13,8 +14,17 @@ import (
 type apiConfig struct {
 	fileserverHits int
 	DB             *DB
-	JWT_SECRET     string
+	Keys           *KeySet
 	PolkaAPIKey    string
+	Profanity      *ProfanityFilter
+	Spam           *SpamPipeline
+	Mailer         Mailer
+	PasswordPolicy PasswordPolicy
+	LoginThrottle  *LoginThrottle
+	// BaseURL is the public address used in links sent to users
+	BaseURL string
+	// actions users with an unverified email can't take
+	UnverifiedRestrictions map[string]bool
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
@@ -33,45 +43,189 @@ func main() {
 	var apiCfg apiConfig
 	var err error
 	godotenv.Load()
-	apiCfg.JWT_SECRET = os.Getenv("JWT_SECRET")
 	apiCfg.PolkaAPIKey = os.Getenv("POLKA_API_KEY")
+	apiCfg.Mailer = NewMailerFromEnv()
+	apiCfg.LoginThrottle = NewLoginThrottle()
+	apiCfg.UnverifiedRestrictions, err = LoadUnverifiedRestrictions(mailDelivered(apiCfg.Mailer))
+	if err != nil {
+		log.Fatalf("Error when loading UNVERIFIED_RESTRICTIONS: %s", err.Error())
+	}
+	apiCfg.BaseURL = os.Getenv("BASE_URL")
+	if apiCfg.BaseURL == "" {
+		apiCfg.BaseURL = "http://localhost:" + port
+	}
 
 	apiCfg.DB, err = NewDB(database)
 
 	dbg := flag.Bool("debug", false, "Enable debug mode")
+	bootstrapAdmin := flag.String("bootstrap-admin", "", "Make the user with this email an admin and exit")
+	repairEmails := flag.Bool("repair-emails", false, "Merge accounts sharing an email, normalize all emails and exit")
+	dryRun := flag.Bool("dry-run", false, "Only report what -repair-emails would do")
+	generateKey := flag.String("generate-jwt-key", "", "Write a new ed25519 or rsa JWT signing key to JWT_KEYS_DIR and exit")
 	flag.Parse()
+
+	keysDir := os.Getenv("JWT_KEYS_DIR")
+	if keysDir == "" {
+		keysDir = defaultJWTKeysDir
+	}
+	if *generateKey != "" {
+		kid, err := GenerateJWTKey(keysDir, *generateKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("wrote signing key %s to %s\n", kid, keysDir)
+		return
+	}
 	if *dbg {
 		err := os.Remove(database)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
+	if *bootstrapAdmin != "" {
+		user, err := apiCfg.DB.GetUserbyMail(*bootstrapAdmin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		_, err = apiCfg.DB.SetRole(user.ID, Actor{Type: ActorSystem}, RoleAdmin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s is now an admin\n", user.Email)
+		return
+	}
+	if *repairEmails {
+		duplicates, err := apiCfg.DB.RepairEmails(*dryRun)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, duplicate := range duplicates {
+			if duplicate.Conflict != "" {
+				log.Printf("%s: skipping users %d and %v, %s\n", duplicate.Email, duplicate.KeepID, duplicate.MergedIDs, duplicate.Conflict)
+				continue
+			}
+			log.Printf("%s: keeping user %d, merging users %v\n", duplicate.Email, duplicate.KeepID, duplicate.MergedIDs)
+		}
+		log.Printf("found %d emails with duplicate accounts\n", len(duplicates))
+		return
+	}
 
 	if err != nil {
 		fmt.Printf("Error when loading DB File: %s", err.Error())
 	}
 
+	// tokens signed with JWT_SECRET are refused once there are signing
+	// keys, except until JWT_SECRET_UNTIL while switching to keys
+	secretUntil := time.Time{}
+	if value := os.Getenv("JWT_SECRET_UNTIL"); value != "" {
+		secretUntil, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			log.Fatalf("Error when parsing JWT_SECRET_UNTIL: %s", err.Error())
+		}
+	}
+	apiCfg.Keys, err = LoadKeySet(keysDir, os.Getenv("JWT_SIGNING_KEY"), os.Getenv("JWT_SECRET"), secretUntil)
+	if err != nil {
+		log.Fatalf("Error when loading JWT keys: %s", err.Error())
+	}
+
+	apiCfg.startChirpSweeper(chirpSweepInterval)
+
+	profanityConfig := os.Getenv("PROFANITY_CONFIG")
+	if profanityConfig == "" {
+		profanityConfig = defaultProfanityConfig
+	}
+	apiCfg.Profanity, err = NewProfanityFilter(profanityConfig)
+	if err != nil {
+		fmt.Printf("Error when loading profanity filter: %s\n", err.Error())
+	}
+	apiCfg.Profanity.Watch(profanityReloadInterval)
+
+	spamConfig := os.Getenv("SPAM_CONFIG")
+	if spamConfig == "" {
+		spamConfig = defaultSpamConfig
+	}
+	spam, err := LoadSpamConfig(spamConfig)
+	if err != nil {
+		fmt.Printf("Error when loading spam config: %s\n", err.Error())
+	}
+	apiCfg.Spam = NewSpamPipeline(spam)
+
+	passwordPolicyConfig := os.Getenv("PASSWORD_POLICY_CONFIG")
+	if passwordPolicyConfig == "" {
+		passwordPolicyConfig = defaultPasswordPolicyConfig
+	}
+	apiCfg.PasswordPolicy, err = LoadPasswordPolicy(passwordPolicyConfig)
+	if err != nil {
+		fmt.Printf("Error when loading password policy: %s\n", err.Error())
+	}
+
 	mux := http.NewServeMux()
 	handler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/", apiCfg.middlewareMetricsInc(handler))
 	mux.HandleFunc("GET /api/healthz", healthz)
-	mux.HandleFunc("GET /admin/metrics", apiCfg.metrics)
-	mux.HandleFunc("/api/reset", apiCfg.reset)
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.GetJWKS)
+	mux.Handle("GET /admin/metrics", apiCfg.middlewareRequireRole(RoleAdmin, apiCfg.metrics))
+	mux.Handle("/api/reset", apiCfg.middlewareRequireRole(RoleAdmin, apiCfg.reset))
+	mux.Handle("GET /admin/moderation", apiCfg.middlewareRequireRole(RoleModerator, apiCfg.GetModerationEvents))
+	mux.Handle("POST /admin/moderation/{eventID}/resolve", apiCfg.middlewareRequireRole(RoleModerator, apiCfg.PostResolveModerationEvent))
+	mux.Handle("GET /admin/chirps/held", apiCfg.middlewareRequireRole(RoleModerator, apiCfg.GetHeldChirps))
+	mux.Handle("POST /admin/chirps/{chirpID}/approve", apiCfg.middlewareRequireRole(RoleModerator, apiCfg.PostApproveChirp))
+	mux.Handle("POST /admin/chirps/{chirpID}/reject", apiCfg.middlewareRequireRole(RoleModerator, apiCfg.PostRejectChirp))
+	mux.Handle("GET /admin/reports", apiCfg.middlewareRequireRole(RoleModerator, apiCfg.GetReports))
+	mux.Handle("GET /admin/reports/{reportID}", apiCfg.middlewareRequireRole(RoleModerator, apiCfg.GetReportID))
+	mux.Handle("POST /admin/reports/{reportID}/resolve", apiCfg.middlewareRequireRole(RoleModerator, apiCfg.PostResolveReport))
+	mux.Handle("GET /admin/audit", apiCfg.middlewareRequireRole(RoleAdmin, apiCfg.GetAuditEvents))
+	mux.Handle("PUT /admin/users/{userID}/role", apiCfg.middlewareRequireRole(RoleAdmin, apiCfg.PutUserRole))
+	mux.Handle("PUT /admin/users/{userID}/shadowban", apiCfg.middlewareRequireRole(RoleAdmin, apiCfg.PutShadowban))
+	mux.Handle("POST /admin/users/{userID}/suspend", apiCfg.middlewareRequireRole(RoleModerator, apiCfg.PostSuspendUser))
+	mux.Handle("POST /admin/users/{userID}/reinstate", apiCfg.middlewareRequireRole(RoleModerator, apiCfg.PostReinstateUser))
+	mux.Handle("POST /admin/users/{userID}/unlock", apiCfg.middlewareRequireRole(RoleAdmin, apiCfg.PostUnlockUser))
 	mux.HandleFunc("POST /api/chirps", apiCfg.PostChirps)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.GetChirpID)
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.DelChirpID)
 	mux.HandleFunc("GET /api/chirps", apiCfg.GetChirps)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/reports", apiCfg.PostReport)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/pin", apiCfg.PostPinChirp)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/pin", apiCfg.DelPinChirp)
+	mux.HandleFunc("GET /api/bookmarks", apiCfg.GetBookmarks)
+	mux.HandleFunc("POST /api/bookmarks/{chirpID}", apiCfg.PostBookmark)
+	mux.HandleFunc("DELETE /api/bookmarks/{chirpID}", apiCfg.DelBookmark)
+	mux.HandleFunc("GET /api/filters", apiCfg.GetFilters)
+	mux.HandleFunc("POST /api/filters", apiCfg.PostFilter)
+	mux.HandleFunc("DELETE /api/filters/{filterID}", apiCfg.DelFilter)
+	mux.HandleFunc("GET /api/mutes", apiCfg.GetMutes)
+	mux.HandleFunc("POST /api/mutes/{userID}", apiCfg.PostMute)
+	mux.HandleFunc("DELETE /api/mutes/{userID}", apiCfg.DelMute)
+	mux.HandleFunc("GET /api/blocks", apiCfg.GetBlocks)
+	mux.HandleFunc("POST /api/blocks/{userID}", apiCfg.PostBlock)
+	mux.HandleFunc("DELETE /api/blocks/{userID}", apiCfg.DelBlock)
 	mux.HandleFunc("POST /api/users", apiCfg.PostUsers)
 	mux.HandleFunc("PUT /api/users", apiCfg.PutUsers)
+	mux.HandleFunc("PUT /api/users/preferences", apiCfg.PutPreferences)
+	mux.HandleFunc("GET /api/users/verify", apiCfg.PostVerifyEmail)
+	mux.HandleFunc("POST /api/users/verify", apiCfg.PostVerifyEmail)
+	mux.HandleFunc("POST /api/users/verify/resend", apiCfg.PostResendVerification)
+	mux.HandleFunc("POST /api/users/password-reset", apiCfg.PostPasswordResetRequest)
+	mux.HandleFunc("POST /api/users/password-reset/confirm", apiCfg.PostPasswordReset)
+	mux.HandleFunc("GET /api/users/{userID}", apiCfg.GetUserProfile)
+	mux.HandleFunc("POST /api/users/{userID}/follow", apiCfg.PostFollow)
+	mux.HandleFunc("DELETE /api/users/{userID}/follow", apiCfg.DelFollow)
 	mux.HandleFunc("POST /api/login", apiCfg.PostLogin)
+	mux.HandleFunc("POST /api/login/2fa", apiCfg.PostLoginTwoFactor)
+	mux.HandleFunc("POST /api/users/2fa", apiCfg.PostEnrollTwoFactor)
+	mux.HandleFunc("POST /api/users/2fa/confirm", apiCfg.PostConfirmTwoFactor)
+	mux.HandleFunc("DELETE /api/users/2fa", apiCfg.DelTwoFactor)
 	mux.HandleFunc("POST /api/refresh", apiCfg.PostRefresh)
 	mux.HandleFunc("POST /api/revoke", apiCfg.PostRevoke)
+	mux.HandleFunc("GET /api/sessions", apiCfg.GetSessions)
+	mux.HandleFunc("DELETE /api/sessions", apiCfg.DelOtherSessions)
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", apiCfg.DelSession)
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.PostPolkaWebhooks)
 	mux.HandleFunc("POST /healthz", posthealthz)
 
 	s := http.Server{
 		Addr:    ":" + port,
-		Handler: mux,
+		Handler: middlewareRequestID(mux),
 	}
 	log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
 	log.Fatal(s.ListenAndServe())
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testPassword = "correct horse battery staple"
+
+// newTestConfig returns a config with an empty database in a temporary
+// directory and tokens signed with a shared secret
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadKeySet(t.TempDir(), "", "test secret", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &apiConfig{
+		DB:                     db,
+		Keys:                   keys,
+		Mailer:                 LogMailer{},
+		PasswordPolicy:         defaultPasswordPolicy,
+		LoginThrottle:          NewLoginThrottle(),
+		UnverifiedRestrictions: map[string]bool{},
+	}
+}
+
+// createTestUser stores a user with testPassword
+func createTestUser(t *testing.T, cfg *apiConfig, email string) User {
+	t.Helper()
+	hash, err := HashPassword(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := cfg.DB.CreateUser(email, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// testRequest calls a handler with body encoded as JSON. The token is sent
+// as bearer token, pathValues are pairs of name and value.
+func testRequest(handler http.HandlerFunc, method string, token string, body interface{}, pathValues ...string) *httptest.ResponseRecorder {
+	dat, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, "/", bytes.NewReader(dat))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(pathValues); i += 2 {
+		req.SetPathValue(pathValues[i], pathValues[i+1])
+	}
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+// testRequestWithToken returns a request carrying token as bearer token
+func testRequestWithToken(token string) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+// decodeResponse decodes the JSON body of a response into v
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	err := json.Unmarshal(w.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("cannot decode %q: %s", w.Body.String(), err)
+	}
+}
+
+type testLogin struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	// set instead of the tokens when a second factor is required
+	ChallengeToken string `json:"challenge_token"`
+}
+
+// loginTestUser logs in with testPassword and fails the test unless the
+// login succeeds with the status want
+func loginTestUser(t *testing.T, cfg *apiConfig, email string, want int) testLogin {
+	t.Helper()
+	w := testRequest(cfg.PostLogin, "POST", "", userparameters{Email: email, Password: testPassword})
+	if w.Code != want {
+		t.Fatalf("login: want status %d, got %d: %s", want, w.Code, w.Body.String())
+	}
+	login := testLogin{}
+	if w.Code < 300 {
+		decodeResponse(t, w, &login)
+	}
+	return login
+}
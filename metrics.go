@@ -21,6 +21,21 @@ func (cfg *apiConfig) metrics(w http.ResponseWriter, req *http.Request) {
 	w.Write([]byte(reply))
 }
 func (cfg *apiConfig) reset(w http.ResponseWriter, req *http.Request) {
+	actorid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	before := cfg.fileserverHits
 	cfg.fileserverHits = 0
+	err = cfg.DB.RecordAudit(userActor(req, actorid), AuditEvent{
+		Action:     "metrics.reset",
+		TargetType: "metrics",
+		Before:     auditState(map[string]int{"fileserver_hits": before}),
+		After:      auditState(map[string]int{"fileserver_hits": 0}),
+	})
+	if err != nil {
+		fmt.Printf("cannot record reset: %s\n", err.Error())
+	}
 	w.Write([]byte("Hits resetted\n"))
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Status of a moderation event queued for moderators
+const (
+	ModerationPending  = "pending"
+	ModerationResolved = "resolved"
+)
+
+var ErrInvalidModerationResolution = errors.New("action must be one of dismiss or hide_chirp")
+
+// ModerationEvent records a profanity rule triggered by a chirp
+type ModerationEvent struct {
+	ID int `json:"id"`
+	// ChirpID is 0 when the chirp was rejected
+	ChirpID   int       `json:"chirp_id"`
+	AuthorID  int       `json:"author_id"`
+	Rule      string    `json:"rule"`
+	Action    string    `json:"action"`
+	Term      string    `json:"term"`
+	Status    string    `json:"status,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// Resolution is one of the report resolutions dismiss or hide_chirp
+	Resolution string     `json:"resolution,omitempty"`
+	ResolvedBy int        `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// moderationEvents turns the rules triggered by a chirp into events,
+// matches of review rules are queued for moderators. A chirpid of 0 is a
+// rejected chirp that was never stored, there is nothing left to review.
+func moderationEvents(chirpid int, authorid int, matches []ProfanityMatch) []ModerationEvent {
+	events := []ModerationEvent{}
+	now := time.Now().UTC()
+	for _, match := range matches {
+		event := ModerationEvent{
+			ChirpID:   chirpid,
+			AuthorID:  authorid,
+			Rule:      match.Rule,
+			Action:    match.Action,
+			Term:      match.Term,
+			CreatedAt: now,
+		}
+		if match.Action == ActionReview && chirpid != 0 {
+			event.Status = ModerationPending
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// rejected reports whether any triggered rule rejects the chirp
+func rejected(matches []ProfanityMatch) bool {
+	for _, match := range matches {
+		if match.Action == ActionReject {
+			return true
+		}
+	}
+	return false
+}
+
+// lists the moderation events, optionally filtered by the query
+// parameters action, status and chirp_id
+func (cfg *apiConfig) GetModerationEvents(w http.ResponseWriter, req *http.Request) {
+	db, err := cfg.DB.loadDB()
+	if err != nil {
+		respondWithError(w, 500, "cannot load db")
+		return
+	}
+	action := req.URL.Query().Get("action")
+	status := req.URL.Query().Get("status")
+	chirpid := 0
+	if schirpid := req.URL.Query().Get("chirp_id"); schirpid != "" {
+		chirpid, err = strconv.Atoi(schirpid)
+		if err != nil {
+			respondWithError(w, 400, "chirp_id could not be parsed")
+			return
+		}
+	}
+
+	events := []ModerationEvent{}
+	for _, event := range db.ModerationEvents {
+		if action != "" && event.Action != action {
+			continue
+		}
+		if status != "" && event.Status != status {
+			continue
+		}
+		if chirpid != 0 && event.ChirpID != chirpid {
+			continue
+		}
+		events = append(events, event)
+	}
+	respondWithJSON(w, 200, events)
+}
+
+// resolves a pending moderation event and all other pending events of the
+// same chirp
+func (cfg *apiConfig) PostResolveModerationEvent(w http.ResponseWriter, req *http.Request) {
+	actorid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	sid := req.PathValue("eventID")
+	eventid, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "event id could not be parsed")
+		return
+	}
+	decoder := json.NewDecoder(req.Body)
+	params := resolveparameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "cannot decode json")
+		return
+	}
+
+	event, err := cfg.DB.ResolveModerationEvent(eventid, userActor(req, actorid), params.Action)
+	if errors.Is(err, ErrInvalidModerationResolution) {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, 404, "Moderation event does not exist")
+		return
+	}
+	if errors.Is(err, ErrAlreadyResolved) {
+		respondWithError(w, 409, "Moderation event is not pending")
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot resolve moderation event: %s\n", err.Error())
+		respondWithError(w, 500, "cannot resolve moderation event")
+		return
+	}
+	respondWithJSON(w, 200, event)
+}
+
+// recordModeration stores the events and only logs failures, the chirp
+// itself has already been handled
+func (cfg *apiConfig) recordModeration(events []ModerationEvent) {
+	if len(events) == 0 {
+		return
+	}
+	err := cfg.DB.AddModerationEvents(events)
+	if err != nil {
+		fmt.Printf("cannot record moderation events: %s\n", err.Error())
+	}
+}
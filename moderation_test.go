@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRejectedChirpEventsAreNotPending(t *testing.T) {
+	matches := []ProfanityMatch{
+		{Rule: "a", Action: ActionReject, Term: "bad"},
+		{Rule: "b", Action: ActionReview, Term: "maybe"},
+	}
+
+	for _, event := range moderationEvents(0, 1, matches) {
+		if event.Status == ModerationPending {
+			t.Errorf("want no pending event for a rejected chirp, got %+v", event)
+		}
+	}
+	events := moderationEvents(7, 1, matches)
+	if events[1].Status != ModerationPending {
+		t.Errorf("want the review match of a stored chirp pending, got %+v", events[1])
+	}
+}
+
+func TestResolveEventWithoutChirpResolvesOnlyItself(t *testing.T) {
+	cfg := newTestConfig(t)
+	// queued as pending before rejected chirps stopped creating pending events
+	err := cfg.DB.AddModerationEvents([]ModerationEvent{
+		{ChirpID: 0, AuthorID: 1, Action: ActionReview, Status: ModerationPending},
+		{ChirpID: 0, AuthorID: 2, Action: ActionReview, Status: ModerationPending},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolved, err := cfg.DB.ResolveModerationEvent(1, Actor{Type: ActorSystem}, ResolutionDismiss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Status != ModerationResolved {
+		t.Errorf("want event 1 resolved, got %+v", resolved)
+	}
+	dbs, err := cfg.DB.loadDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other := dbs.ModerationEvents[1]; other.Status != ModerationPending {
+		t.Errorf("want the event of the other author still pending, got %+v", other)
+	}
+}
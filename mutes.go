@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+var ErrBlocked = errors.New("blocked by user")
+
+// Lists of users a user does not want to hear from. Muted users only
+// disappear from the listing of the muting user, blocked users in addition
+// cannot interact with the blocking user.
+const (
+	ListMutes  = "mutes"
+	ListBlocks = "blocks"
+)
+
+// contains reports whether ids contains id
+func contains(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// IsMuted reports whether the chirps of author are filtered out for viewer,
+// which is the case for muted and blocked users
+func (dbs DBStructure) IsMuted(viewerid, authorid int) bool {
+	return contains(dbs.Mutes[viewerid], authorid) || dbs.IsBlocked(viewerid, authorid)
+}
+
+// IsBlocked reports whether blocker has blocked the user blocked
+func (dbs DBStructure) IsBlocked(blocker, blocked int) bool {
+	return contains(dbs.Blocks[blocker], blocked)
+}
+
+// userList returns the list of the given kind
+func (dbs DBStructure) userList(list string) map[int][]int {
+	if list == ListBlocks {
+		return dbs.Blocks
+	}
+	return dbs.Mutes
+}
+
+// mutes a user for the authenticated user
+func (cfg *apiConfig) PostMute(w http.ResponseWriter, req *http.Request) {
+	cfg.addToUserList(w, req, ListMutes)
+}
+
+// unmutes a user for the authenticated user
+func (cfg *apiConfig) DelMute(w http.ResponseWriter, req *http.Request) {
+	cfg.removeFromUserList(w, req, ListMutes)
+}
+
+// lists the users muted by the authenticated user
+func (cfg *apiConfig) GetMutes(w http.ResponseWriter, req *http.Request) {
+	cfg.getUserList(w, req, ListMutes)
+}
+
+// blocks a user for the authenticated user
+func (cfg *apiConfig) PostBlock(w http.ResponseWriter, req *http.Request) {
+	cfg.addToUserList(w, req, ListBlocks)
+}
+
+// unblocks a user for the authenticated user
+func (cfg *apiConfig) DelBlock(w http.ResponseWriter, req *http.Request) {
+	cfg.removeFromUserList(w, req, ListBlocks)
+}
+
+// lists the users blocked by the authenticated user
+func (cfg *apiConfig) GetBlocks(w http.ResponseWriter, req *http.Request) {
+	cfg.getUserList(w, req, ListBlocks)
+}
+
+func (cfg *apiConfig) addToUserList(w http.ResponseWriter, req *http.Request, list string) {
+	userid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	sid := req.PathValue("userID")
+	otherid, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "user id could not be parsed")
+		return
+	}
+	if otherid == userid {
+		respondWithError(w, 400, "cannot add yourself to "+list)
+		return
+	}
+
+	err = cfg.DB.AddToUserList(list, userid, otherid)
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, 404, "User does not exist")
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot update %s: %s\n", list, err.Error())
+		respondWithError(w, 500, "cannot update "+list)
+		return
+	}
+	respondWithJSON(w, 200, "User added to "+list)
+}
+
+func (cfg *apiConfig) removeFromUserList(w http.ResponseWriter, req *http.Request, list string) {
+	userid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	sid := req.PathValue("userID")
+	otherid, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "user id could not be parsed")
+		return
+	}
+
+	err = cfg.DB.RemoveFromUserList(list, userid, otherid)
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, 404, "User is not in "+list)
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot update %s: %s\n", list, err.Error())
+		respondWithError(w, 500, "cannot update "+list)
+		return
+	}
+	respondWithJSON(w, 200, "User removed from "+list)
+}
+
+func (cfg *apiConfig) getUserList(w http.ResponseWriter, req *http.Request, list string) {
+	userid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	db, err := cfg.DB.loadDB()
+	if err != nil {
+		respondWithError(w, 500, "cannot load db")
+		return
+	}
+	ids := db.userList(list)[userid]
+	if ids == nil {
+		ids = []int{}
+	}
+	respondWithJSON(w, 200, ids)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const passwordResetTTL = time.Hour
+
+// PasswordReset is a pending password reset. Only the hash of the token
+// is stored.
+type PasswordReset struct {
+	UserID    int       `json:"user_id"`
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type resetrequestparameters struct {
+	Email string `json:"email"`
+}
+
+type resetparameters struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// sendPasswordReset issues a reset token for the user and mails it
+func (cfg *apiConfig) sendPasswordReset(user User) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	err = cfg.DB.CreatePasswordReset(hashToken(token), user.ID, user.Email, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+	return cfg.Mailer.Send(Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your account. Your reset token is\n\n%s\n\nIt is valid for %s. If this wasn't you, ignore this mail.\n",
+			token, passwordResetTTL),
+	})
+}
+
+// starts a password reset. The response is the same whether the email
+// belongs to an account or not, the mail is sent in the background so the
+// response time doesn't tell either.
+func (cfg *apiConfig) PostPasswordResetRequest(w http.ResponseWriter, req *http.Request) {
+	params := resetrequestparameters{}
+	err := json.NewDecoder(req.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "cannot decode json")
+		return
+	}
+
+	go func(email string) {
+		user, err := cfg.DB.GetUserbyMail(email)
+		if err != nil {
+			return
+		}
+		err = cfg.sendPasswordReset(user)
+		if err != nil {
+			fmt.Printf("cannot send password reset mail: %s\n", err.Error())
+		}
+	}(params.Email)
+
+	respondWithJSON(w, 202, "If the email belongs to an account, a reset token is on its way")
+}
+
+// sets a new password with a reset token and signs out all sessions
+func (cfg *apiConfig) PostPasswordReset(w http.ResponseWriter, req *http.Request) {
+	params := resetparameters{}
+	err := json.NewDecoder(req.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "cannot decode json")
+		return
+	}
+	if params.Token == "" || params.Password == "" {
+		respondWithError(w, 400, "token and password are required")
+		return
+	}
+	if err := cfg.PasswordPolicy.Validate(params.Password); err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	pw, err := HashPassword(params.Password)
+	if err != nil {
+		fmt.Printf("cannot hash password: %s", err.Error())
+		respondWithError(w, 400, "cannot hash password")
+		return
+	}
+	_, err = cfg.DB.ResetPassword(hashToken(params.Token), pw)
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, 400, "token is invalid or expired")
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot reset password: %s\n", err.Error())
+		respondWithError(w, 500, "cannot reset password")
+		return
+	}
+	w.WriteHeader(204)
+}
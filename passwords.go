@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+const defaultPasswordPolicyConfig = "./password_policy.json"
+
+// bcrypt ignores everything after the first 72 bytes of a password
+const bcryptMaxBytes = 72
+
+// length of the hash prefix shared by a k-anonymity range
+const breachedPrefixLength = 5
+
+var ErrBreachedPassword = errors.New("password is known from a data breach, choose another one")
+
+// PasswordPolicy holds the rules new passwords have to follow
+type PasswordPolicy struct {
+	MinLength int `json:"min_length"`
+	// MaxBytes can't be raised above the 72 bytes bcrypt hashes
+	MaxBytes int `json:"max_bytes"`
+	// MinClasses is the number of character classes (lower case, upper
+	// case, digits, symbols) a password has to mix
+	MinClasses int `json:"min_classes"`
+	// BreachedPasswords is a directory of k-anonymity range files named by
+	// the first 5 hex digits of the SHA-1 hash, or a single file, holding
+	// "SUFFIX:COUNT" or "HASH:COUNT" lines. Empty disables the check.
+	BreachedPasswords string `json:"breached_passwords"`
+}
+
+var defaultPasswordPolicy = PasswordPolicy{
+	MinLength:  8,
+	MaxBytes:   bcryptMaxBytes,
+	MinClasses: 2,
+}
+
+// LoadPasswordPolicy reads the password policy file, settings missing in
+// the file keep their defaults
+func LoadPasswordPolicy(path string) (PasswordPolicy, error) {
+	policy := defaultPasswordPolicy
+	dat, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return policy, nil
+	}
+	if err != nil {
+		return policy, err
+	}
+	err = json.Unmarshal(dat, &policy)
+	if err != nil {
+		return defaultPasswordPolicy, fmt.Errorf("cannot parse %s: %w", path, err)
+	}
+	if policy.MaxBytes <= 0 || policy.MaxBytes > bcryptMaxBytes {
+		policy.MaxBytes = bcryptMaxBytes
+	}
+	return policy, nil
+}
+
+// Validate checks a new password against the policy
+func (p PasswordPolicy) Validate(password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", p.MinLength)
+	}
+	if len(password) > p.MaxBytes {
+		return fmt.Errorf("password must not be longer than %d bytes", p.MaxBytes)
+	}
+	if classes := characterClasses(password); classes < p.MinClasses {
+		return fmt.Errorf("password must mix at least %d of lower case letters, upper case letters, digits and symbols", p.MinClasses)
+	}
+	if p.BreachedPasswords == "" {
+		return nil
+	}
+	breached, err := isBreached(p.BreachedPasswords, password)
+	if err != nil {
+		// a broken list must not lock everyone out of changing passwords
+		fmt.Printf("cannot check breached passwords: %s\n", err.Error())
+		return nil
+	}
+	if breached {
+		return ErrBreachedPassword
+	}
+	return nil
+}
+
+// characterClasses counts the character classes used in a password
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, used := range []bool{lower, upper, digit, symbol} {
+		if used {
+			classes++
+		}
+	}
+	return classes
+}
+
+// isBreached looks up the SHA-1 hash of a password in the breached
+// password list. With a range directory only the file of the hash prefix
+// is read, like the k-anonymity API of Have I Been Pwned.
+func isBreached(path string, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:breachedPrefixLength], hash[breachedPrefixLength:]
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if stat.IsDir() {
+		path = filepath.Join(path, prefix+".txt")
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		entry = strings.ToUpper(entry)
+		if entry == hash || (stat.IsDir() && entry == suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type profile struct {
+	ID          int     `json:"id"`
+	IsChirpyRed bool    `json:"is_chirpy_red"`
+	PinnedChirp *Chirp  `json:"pinned_chirp,omitempty"`
+	Chirps      []Chirp `json:"chirps"`
+}
+
+// pins one of the users own chirps to the top of their profile
+func (cfg *apiConfig) PostPinChirp(w http.ResponseWriter, req *http.Request) {
+	userid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	db, err := cfg.DB.loadDB()
+	if err != nil {
+		respondWithError(w, 500, "cannot load db")
+		return
+	}
+	sid := req.PathValue("chirpID")
+	chirpid, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "Chirp id could not be parsed")
+		return
+	}
+	chirp, exists := db.Chirps[chirpid]
+	if !exists || !db.CanView(userid, chirp, time.Now().UTC()) {
+		respondWithError(w, 404, "Chirp does not exist")
+		return
+	}
+	user, err := cfg.DB.GetUserbyID(userid)
+	if err != nil {
+		respondWithError(w, 500, "cannot get user by id")
+		return
+	}
+
+	if chirp.AuthorID != user.ID {
+		respondWithError(w, 403, "Unauthorized - different user")
+		return
+	}
+
+	err = cfg.DB.SetPinnedChirp(user.ID, chirp.ID)
+	if err != nil {
+		fmt.Printf("cannot pin chirp: %s\n", err.Error())
+		respondWithError(w, 500, "cannot pin chirp")
+		return
+	}
+	respondWithJSON(w, 200, chirp)
+}
+
+// removes the pin from the users profile
+func (cfg *apiConfig) DelPinChirp(w http.ResponseWriter, req *http.Request) {
+	userid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	sid := req.PathValue("chirpID")
+	chirpid, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "Chirp id could not be parsed")
+		return
+	}
+	user, err := cfg.DB.GetUserbyID(userid)
+	if err != nil {
+		respondWithError(w, 500, "cannot get user by id")
+		return
+	}
+	if user.PinnedChirpID != chirpid {
+		respondWithError(w, 404, "Chirp is not pinned")
+		return
+	}
+
+	err = cfg.DB.SetPinnedChirp(user.ID, 0)
+	if err != nil {
+		fmt.Printf("cannot unpin chirp: %s\n", err.Error())
+		respondWithError(w, 500, "cannot unpin chirp")
+		return
+	}
+	respondWithJSON(w, 200, "Chirp unpinned")
+}
+
+// returns the public profile of a user with the pinned chirp first
+func (cfg *apiConfig) GetUserProfile(w http.ResponseWriter, req *http.Request) {
+	viewerid, err := cfg.OptionalViewer(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	db, err := cfg.DB.loadDB()
+	if err != nil {
+		respondWithError(w, 500, "cannot load db")
+		return
+	}
+	sid := req.PathValue("userID")
+	userid, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "user id could not be parsed")
+		return
+	}
+	user, exists := db.Users[userid]
+	if !exists {
+		respondWithError(w, 404, "User does not exist")
+		return
+	}
+
+	now := time.Now().UTC()
+	// the profile of a muted or blocked user shows no chirps
+	muted := db.IsMuted(viewerid, user.ID)
+	Chirps := []Chirp{}
+	for _, chirp := range db.Chirps {
+		if chirp.AuthorID == user.ID && !muted && db.CanView(viewerid, chirp, now) {
+			Chirps = append(Chirps, db.showChirp(viewerid, chirp))
+		}
+	}
+	Chirps = PinnedFirst(SortingChirps(Chirps, "desc"), user.PinnedChirpID)
+
+	userProfile := profile{
+		ID:          user.ID,
+		IsChirpyRed: user.IsChirpyRed,
+		Chirps:      Chirps,
+	}
+	if len(Chirps) > 0 && Chirps[0].ID == user.PinnedChirpID {
+		userProfile.PinnedChirp = &Chirps[0]
+	}
+	respondWithJSON(w, 200, userProfile)
+}
+
+// PinnedFirst moves the pinned chirp to the front of an already sorted list
+func PinnedFirst(Chirps []Chirp, pinnedID int) []Chirp {
+	if pinnedID == 0 {
+		return Chirps
+	}
+	for i, chirp := range Chirps {
+		if chirp.ID == pinnedID {
+			copy(Chirps[1:i+1], Chirps[:i])
+			Chirps[0] = chirp
+			break
+		}
+	}
+	return Chirps
+}
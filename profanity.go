@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const defaultProfanityConfig = "./profanity.json"
+const profanityReloadInterval = 5 * time.Second
+const profanityMask = "****"
+
+// Actions a profanity rule can take when it matches a word
+const (
+	// ActionMask replaces the word with the mask
+	ActionMask = "mask"
+	// ActionReject refuses the chirp
+	ActionReject = "reject"
+	// ActionReview accepts the chirp unchanged but queues it for review
+	ActionReview = "review"
+)
+
+// ProfanityRule is a set of words and patterns sharing one action
+type ProfanityRule struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	// Words are matched as whole words in every language
+	Words []string `json:"words"`
+	// Patterns are regular expressions that have to match a whole word
+	Patterns []string `json:"patterns"`
+	// Languages holds additional word lists per language code
+	Languages map[string][]string `json:"languages"`
+}
+
+// ProfanityConfig is the content of the profanity filter config file.
+// The top level lists form the rule "default" which masks words.
+type ProfanityConfig struct {
+	Words     []string            `json:"words"`
+	Patterns  []string            `json:"patterns"`
+	Languages map[string][]string `json:"languages"`
+	Rules     []ProfanityRule     `json:"rules"`
+	// EnabledLanguages limits the language lists in use, empty enables all
+	EnabledLanguages []string `json:"enabled_languages"`
+}
+
+// ProfanityMatch is a word that triggered a rule
+type ProfanityMatch struct {
+	Rule   string `json:"rule"`
+	Action string `json:"action"`
+	Term   string `json:"term"`
+}
+
+// the word list used when no config file exists
+var defaultProfanity = ProfanityConfig{
+	Words: []string{"kerfuffle", "sharbert", "fornax"},
+}
+
+type profanityRule struct {
+	name    string
+	action  string
+	matcher []*regexp.Regexp
+}
+
+// leetspeak substitutions accepted for each letter
+var leetSubstitutions = map[rune]string{
+	'a': "4@",
+	'b': "8",
+	'e': "3",
+	'g': "9",
+	'i': "1!|",
+	'l': "1|",
+	'o': "0",
+	's': "5$",
+	't': "7+",
+	'z': "2",
+}
+
+// ProfanityFilter masks profane words in chirps. The word lists are loaded
+// from a config file and reloaded whenever the file changes.
+type ProfanityFilter struct {
+	path    string
+	mux     *sync.RWMutex
+	rules   []profanityRule
+	modTime time.Time
+}
+
+func NewProfanityFilter(path string) (*ProfanityFilter, error) {
+	f := ProfanityFilter{
+		path: path,
+		mux:  &sync.RWMutex{},
+	}
+	err := f.load()
+	if err != nil {
+		// keep filtering with the default word list until the file is fixed
+		f.rules, _ = compileProfanity(defaultProfanity)
+	}
+	return &f, err
+}
+
+// load reads the config file and swaps in the compiled matchers.
+// On error the previous matchers stay active.
+func (f *ProfanityFilter) load() error {
+	config := defaultProfanity
+	var modTime time.Time
+	stat, err := os.Stat(f.path)
+	if err == nil {
+		modTime = stat.ModTime()
+		dat, err := os.ReadFile(f.path)
+		if err != nil {
+			return err
+		}
+		config = ProfanityConfig{}
+		err = json.Unmarshal(dat, &config)
+		if err != nil {
+			return fmt.Errorf("cannot parse %s: %w", f.path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	rules, err := compileProfanity(config)
+	if err != nil {
+		return fmt.Errorf("cannot compile %s: %w", f.path, err)
+	}
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.rules = rules
+	f.modTime = modTime
+	return nil
+}
+
+// Watch reloads the config file in the background whenever it changes
+func (f *ProfanityFilter) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for range ticker.C {
+			var modTime time.Time
+			stat, err := os.Stat(f.path)
+			if err == nil {
+				modTime = stat.ModTime()
+			}
+			f.mux.RLock()
+			changed := !modTime.Equal(f.modTime)
+			f.mux.RUnlock()
+			if !changed {
+				continue
+			}
+			err = f.load()
+			if err != nil {
+				log.Printf("error reloading profanity filter: %s\n", err)
+				continue
+			}
+			log.Printf("reloaded profanity filter from %s\n", f.path)
+		}
+	}()
+}
+
+// compileProfanity compiles the default rule and all configured rules
+func compileProfanity(config ProfanityConfig) ([]profanityRule, error) {
+	enabled := map[string]bool{}
+	for _, lang := range config.EnabledLanguages {
+		enabled[lang] = true
+	}
+	rules := append([]ProfanityRule{{
+		Name:      "default",
+		Action:    ActionMask,
+		Words:     config.Words,
+		Patterns:  config.Patterns,
+		Languages: config.Languages,
+	}}, config.Rules...)
+
+	compiled := []profanityRule{}
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i)
+		}
+		if rule.Action == "" {
+			rule.Action = ActionMask
+		}
+		if rule.Action != ActionMask && rule.Action != ActionReject && rule.Action != ActionReview {
+			return nil, fmt.Errorf("unknown action %q in rule %s", rule.Action, rule.Name)
+		}
+		matcher, err := compileRule(rule, enabled)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+		compiled = append(compiled, profanityRule{
+			name:    rule.Name,
+			action:  rule.Action,
+			matcher: matcher,
+		})
+	}
+	return compiled, nil
+}
+
+// compileRule builds one matcher for all words of a rule and one per
+// pattern. Every matcher has to match a whole word.
+func compileRule(rule ProfanityRule, enabled map[string]bool) ([]*regexp.Regexp, error) {
+	words := append([]string{}, rule.Words...)
+	for lang, list := range rule.Languages {
+		if len(enabled) == 0 || enabled[lang] {
+			words = append(words, list...)
+		}
+	}
+
+	alternatives := []string{}
+	for _, word := range words {
+		word = strings.TrimSpace(word)
+		if word == "" {
+			continue
+		}
+		alternatives = append(alternatives, leetPattern(word))
+	}
+	// longer words first so the alternation prefers the longest match
+	sort.Slice(alternatives, func(i, j int) bool {
+		return len(alternatives[i]) > len(alternatives[j])
+	})
+
+	matcher := []*regexp.Regexp{}
+	if len(alternatives) > 0 {
+		re, err := regexp.Compile(`(?i)^(?:` + strings.Join(alternatives, "|") + `)$`)
+		if err != nil {
+			return nil, err
+		}
+		matcher = append(matcher, re)
+	}
+	for _, pattern := range rule.Patterns {
+		re, err := regexp.Compile(`(?i)^(?:` + pattern + `)$`)
+		if err != nil {
+			return nil, err
+		}
+		matcher = append(matcher, re)
+	}
+	return matcher, nil
+}
+
+// leetPattern turns a word into a regular expression that also accepts
+// the common leetspeak substitutions of its letters
+func leetPattern(word string) string {
+	var pattern strings.Builder
+	for _, r := range strings.ToLower(word) {
+		subs, ok := leetSubstitutions[r]
+		if !ok {
+			pattern.WriteString(regexp.QuoteMeta(string(r)))
+			continue
+		}
+		pattern.WriteString("[")
+		pattern.WriteString(regexp.QuoteMeta(string(r)))
+		for _, sub := range subs {
+			pattern.WriteString(regexp.QuoteMeta(string(sub)))
+		}
+		pattern.WriteString("]")
+	}
+	return pattern.String()
+}
+
+// isWordRune reports whether r can be part of a word, this includes the
+// symbols used in leetspeak
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || strings.ContainsRune("@!|$+", r)
+}
+
+// Check runs all rules against body. It returns body with every word
+// matched by a mask rule masked, keeping everything around it including
+// whitespace and punctuation as it is, and every triggered rule.
+func (f *ProfanityFilter) Check(body string) (cleaned string, matches []ProfanityMatch) {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	var cleanedBody strings.Builder
+	runes := []rune(body)
+	for start := 0; start < len(runes); {
+		end := start
+		for end < len(runes) && isWordRune(runes[end]) {
+			end++
+		}
+		if end == start {
+			cleanedBody.WriteRune(runes[start])
+			start++
+			continue
+		}
+		word, wordMatches := f.checkWord(string(runes[start:end]))
+		cleanedBody.WriteString(word)
+		matches = append(matches, wordMatches...)
+		start = end
+	}
+	return cleanedBody.String(), matches
+}
+
+// checkWord checks a single word. Leetspeak symbols at the start or end of
+// a word are more likely punctuation ("Kerfuffle!"), so if the whole word
+// doesn't match they are kept and only the core of the word is checked.
+func (f *ProfanityFilter) checkWord(word string) (string, []ProfanityMatch) {
+	if matches := f.matches(word); len(matches) > 0 {
+		if maskMatched(matches) {
+			return profanityMask, matches
+		}
+		return word, matches
+	}
+	notLetterOrDigit := func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}
+	core := strings.TrimLeftFunc(word, notLetterOrDigit)
+	prefix := word[:len(word)-len(core)]
+	core = strings.TrimRightFunc(core, notLetterOrDigit)
+	suffix := word[len(prefix)+len(core):]
+	if core == word || core == "" {
+		return word, nil
+	}
+	matches := f.matches(core)
+	if maskMatched(matches) {
+		return prefix + profanityMask + suffix, matches
+	}
+	return word, matches
+}
+
+// matches returns every rule that matches word
+func (f *ProfanityFilter) matches(word string) []ProfanityMatch {
+	matches := []ProfanityMatch{}
+	for _, rule := range f.rules {
+		for _, re := range rule.matcher {
+			if re.MatchString(word) {
+				matches = append(matches, ProfanityMatch{
+					Rule:   rule.name,
+					Action: rule.action,
+					Term:   word,
+				})
+				break
+			}
+		}
+	}
+	return matches
+}
+
+func maskMatched(matches []ProfanityMatch) bool {
+	for _, match := range matches {
+		if match.Action == ActionMask {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Status of a report
+const (
+	ReportOpen     = "open"
+	ReportResolved = "resolved"
+)
+
+// Resolutions a moderator can choose for a report
+const (
+	ResolutionDismiss       = "dismiss"
+	ResolutionHideChirp     = "hide_chirp"
+	ResolutionSuspendAuthor = "suspend_author"
+)
+
+// reason codes accepted for a report
+var reportReasons = map[string]struct{}{
+	"spam":       {},
+	"harassment": {},
+	"hate":       {},
+	"violence":   {},
+	"sensitive":  {},
+	"other":      {},
+}
+
+const maxReportCommentLength = 500
+
+var ErrInvalidResolution = errors.New("action must be one of dismiss, hide_chirp or suspend_author")
+var ErrAlreadyResolved = errors.New("report is already resolved")
+
+type Report struct {
+	ID         int        `json:"id"`
+	ChirpID    int        `json:"chirp_id"`
+	ReporterID int        `json:"reporter_id"`
+	Reason     string     `json:"reason"`
+	Comment    string     `json:"comment,omitempty"`
+	Status     string     `json:"status"`
+	Resolution string     `json:"resolution,omitempty"`
+	ResolvedBy int        `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type reportparameters struct {
+	Reason  string `json:"reason"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type resolveparameters struct {
+	Action string `json:"action"`
+	// DurationSeconds of a suspension, 0 suspends permanently
+	DurationSeconds int `json:"duration_seconds,omitempty"`
+}
+
+// reportedAuthor is what moderators get to see about the author of a chirp
+type reportedAuthor struct {
+	ID             int        `json:"id"`
+	Email          string     `json:"email"`
+	Suspended      bool       `json:"suspended"`
+	SuspendedUntil *time.Time `json:"suspended_until,omitempty"`
+}
+
+type reportView struct {
+	Report
+	Chirp  *Chirp          `json:"chirp,omitempty"`
+	Author *reportedAuthor `json:"author,omitempty"`
+}
+
+// reports a chirp to the moderators. Reporting the same chirp twice
+// returns the existing report.
+func (cfg *apiConfig) PostReport(w http.ResponseWriter, req *http.Request) {
+	userid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	if !cfg.requireVerified(w, userid, RestrictReports) {
+		return
+	}
+	db, err := cfg.DB.loadDB()
+	if err != nil {
+		respondWithError(w, 500, "cannot load db")
+		return
+	}
+	sid := req.PathValue("chirpID")
+	chirpid, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "Chirp id could not be parsed")
+		return
+	}
+	chirp, exists := db.Chirps[chirpid]
+	if !exists || !db.CanView(userid, chirp, time.Now().UTC()) {
+		respondWithError(w, 404, "Chirp does not exist")
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := reportparameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "cannot decode json")
+		return
+	}
+	if _, ok := reportReasons[params.Reason]; !ok {
+		respondWithError(w, 400, "reason must be one of spam, harassment, hate, violence, sensitive or other")
+		return
+	}
+	if len(params.Comment) > maxReportCommentLength {
+		respondWithError(w, 400, "Comment is too long")
+		return
+	}
+
+	report, created, err := cfg.DB.CreateReport(Report{
+		ChirpID:    chirp.ID,
+		ReporterID: userid,
+		Reason:     params.Reason,
+		Comment:    params.Comment,
+	})
+	if err != nil {
+		fmt.Printf("cannot create report: %s\n", err.Error())
+		respondWithError(w, 500, "cannot create report")
+		return
+	}
+	if !created {
+		respondWithJSON(w, 200, report)
+		return
+	}
+	respondWithJSON(w, 201, report)
+}
+
+// lists the reports with the reported chirp and its author, only open
+// reports unless the query parameter status is given
+func (cfg *apiConfig) GetReports(w http.ResponseWriter, req *http.Request) {
+	db, err := cfg.DB.loadDB()
+	if err != nil {
+		respondWithError(w, 500, "cannot load db")
+		return
+	}
+	status := req.URL.Query().Get("status")
+	if status == "" {
+		status = ReportOpen
+	}
+
+	reports := []reportView{}
+	for _, report := range db.Reports {
+		if status != "all" && report.Status != status {
+			continue
+		}
+		reports = append(reports, db.reportView(report))
+	}
+	respondWithJSON(w, 200, reports)
+}
+
+// returns a single report with the reported chirp and its author
+func (cfg *apiConfig) GetReportID(w http.ResponseWriter, req *http.Request) {
+	db, err := cfg.DB.loadDB()
+	if err != nil {
+		respondWithError(w, 500, "cannot load db")
+		return
+	}
+	sid := req.PathValue("reportID")
+	reportid, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "report id could not be parsed")
+		return
+	}
+	if reportid < 1 || reportid > len(db.Reports) {
+		respondWithError(w, 404, "Report does not exist")
+		return
+	}
+	respondWithJSON(w, 200, db.reportView(db.Reports[reportid-1]))
+}
+
+// resolves a report and all other open reports of the same chirp
+func (cfg *apiConfig) PostResolveReport(w http.ResponseWriter, req *http.Request) {
+	actorid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	sid := req.PathValue("reportID")
+	reportid, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "report id could not be parsed")
+		return
+	}
+	decoder := json.NewDecoder(req.Body)
+	params := resolveparameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "cannot decode json")
+		return
+	}
+	if params.DurationSeconds < 0 {
+		respondWithError(w, 400, "duration_seconds must not be negative")
+		return
+	}
+
+	report, err := cfg.DB.ResolveReport(reportid, userActor(req, actorid), params.Action, time.Duration(params.DurationSeconds)*time.Second)
+	if errors.Is(err, ErrInvalidResolution) {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, 404, "Report does not exist")
+		return
+	}
+	if errors.Is(err, ErrOutranked) {
+		respondWithError(w, 403, "Forbidden - "+err.Error())
+		return
+	}
+	if errors.Is(err, ErrAlreadyResolved) {
+		respondWithError(w, 409, "Report is already resolved")
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot resolve report: %s\n", err.Error())
+		respondWithError(w, 500, "cannot resolve report")
+		return
+	}
+	respondWithJSON(w, 200, report)
+}
+
+// reportView adds the reported chirp and its author to a report
+func (dbs DBStructure) reportView(report Report) reportView {
+	view := reportView{Report: report}
+	chirp, exists := dbs.Chirps[report.ChirpID]
+	if !exists {
+		return view
+	}
+	view.Chirp = &chirp
+	author, exists := dbs.Users[chirp.AuthorID]
+	if exists {
+		view.Author = &reportedAuthor{
+			ID:             author.ID,
+			Email:          author.Email,
+			Suspended:      author.Suspended,
+			SuspendedUntil: author.SuspendedUntil,
+		}
+	}
+	return view
+}
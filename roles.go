@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Roles of a user, every role includes the permissions of the roles before it
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleLevels = map[string]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+var ErrInvalidRole = errors.New("role must be one of user, moderator or admin")
+
+type roleparameters struct {
+	Role string `json:"role"`
+}
+
+// RoleOrDefault returns the role of the user, users stored before roles
+// existed are plain users
+func (u User) RoleOrDefault() string {
+	if u.Role == "" {
+		return RoleUser
+	}
+	return u.Role
+}
+
+// hasRole reports whether role includes the permissions of required
+func hasRole(role string, required string) bool {
+	if role == "" {
+		role = RoleUser
+	}
+	level, ok := roleLevels[role]
+	return ok && level >= roleLevels[required]
+}
+
+// middlewareRequireRole only lets requests through whose access token
+// carries at least the required role. The role is checked against the
+// database as well, so a demoted user loses access before the token expires.
+func (cfg *apiConfig) middlewareRequireRole(required string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		userid, claims, err := cfg.ValidateHeaderClaims(req)
+		if err != nil {
+			respondWithError(w, 401, "Unauthorized")
+			return
+		}
+		user, err := cfg.DB.GetUserbyID(userid)
+		if err != nil {
+			respondWithError(w, 401, "Unauthorized")
+			return
+		}
+		if !hasRole(claims.Role, required) || !hasRole(user.Role, required) {
+			respondWithError(w, 403, "Forbidden - requires role "+required)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// changes the role of a user
+func (cfg *apiConfig) PutUserRole(w http.ResponseWriter, req *http.Request) {
+	actorid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	sid := req.PathValue("userID")
+	userid, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "user id could not be parsed")
+		return
+	}
+	decoder := json.NewDecoder(req.Body)
+	params := roleparameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "cannot decode json")
+		return
+	}
+	if userid == actorid && params.Role != RoleAdmin {
+		respondWithError(w, 400, "admins cannot demote themselves")
+		return
+	}
+
+	user, err := cfg.DB.SetRole(userid, userActor(req, actorid), params.Role)
+	if errors.Is(err, ErrInvalidRole) {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, 404, "User does not exist")
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot set role: %s\n", err.Error())
+		respondWithError(w, 500, "cannot set role")
+		return
+	}
+	respondWithJSON(w, 200, roleparameters{Role: user.RoleOrDefault()})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const refreshTokenTTL = 60 * 24 * time.Hour
+
+const maxDeviceNameLength = 100
+
+// rotated refresh tokens remembered per session for reuse detection, older
+// ones are only refused like unknown tokens
+const maxRotatedTokenHashes = 100
+
+var ErrSessionRevoked = errors.New("session was revoked")
+var ErrRefreshTokenReused = errors.New("refresh token was already used")
+
+// Session is a login on one device, identified by its refresh token. Every
+// refresh replaces the token, the session is the family of all its tokens.
+type Session struct {
+	ID     int `json:"id"`
+	UserID int `json:"user_id"`
+	// only the SHA-256 hashes of refresh tokens are stored
+	RefreshTokenHash string `json:"refresh_token_hash"`
+	// RotatedTokenHashes were replaced by a refresh, presenting one of
+	// them again means the token was stolen
+	RotatedTokenHashes []string  `json:"rotated_token_hashes,omitempty"`
+	DeviceName         string    `json:"device_name"`
+	IP                 string    `json:"ip"`
+	UserAgent          string    `json:"user_agent"`
+	CreatedAt          time.Time `json:"created_at"`
+	LastUsedAt         time.Time `json:"last_used_at"`
+	ExpiresAt          time.Time `json:"expires_at"`
+}
+
+// sessionResponse is a session as shown to its user, without the token
+type sessionResponse struct {
+	ID         int       `json:"id"`
+	DeviceName string    `json:"device_name"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Current marks the session the request was made with
+	Current bool `json:"current"`
+}
+
+// clientIP returns the address of the client without the port
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// bearerToken returns the token of an Authorization header
+func bearerToken(req *http.Request) (string, error) {
+	token, found := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return "", errors.New("malformed Authorization Header")
+	}
+	return token, nil
+}
+
+// lists the sessions of the authenticated user, most recently used first
+func (cfg *apiConfig) GetSessions(w http.ResponseWriter, req *http.Request) {
+	userid, claims, err := cfg.ValidateHeaderClaims(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	db, err := cfg.DB.loadDB()
+	if err != nil {
+		respondWithError(w, 500, "cannot load db")
+		return
+	}
+	now := time.Now().UTC()
+	sessions := []sessionResponse{}
+	for _, session := range db.Sessions {
+		if session.UserID != userid || !session.ExpiresAt.After(now) {
+			continue
+		}
+		sessions = append(sessions, sessionResponse{
+			ID:         session.ID,
+			DeviceName: session.DeviceName,
+			IP:         session.IP,
+			UserAgent:  session.UserAgent,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.ID == claims.SessionID,
+		})
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+	respondWithJSON(w, 200, sessions)
+}
+
+// signs out one session of the authenticated user
+func (cfg *apiConfig) DelSession(w http.ResponseWriter, req *http.Request) {
+	userid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	sid := req.PathValue("sessionID")
+	sessionid, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "Session id could not be parsed")
+		return
+	}
+
+	err = cfg.DB.DelSession(userid, sessionid)
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, 404, "Session does not exist")
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot revoke session: %s\n", err.Error())
+		respondWithError(w, 500, "cannot revoke session")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// signs out every session of the authenticated user but the current one
+func (cfg *apiConfig) DelOtherSessions(w http.ResponseWriter, req *http.Request) {
+	userid, claims, err := cfg.ValidateHeaderClaims(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	revoked, err := cfg.DB.DelSessions(userid, claims.SessionID)
+	if err != nil {
+		fmt.Printf("cannot revoke sessions: %s\n", err.Error())
+		respondWithError(w, 500, "cannot revoke sessions")
+		return
+	}
+	type response struct {
+		Revoked int `json:"revoked"`
+	}
+	respondWithJSON(w, 200, response{Revoked: revoked})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// refreshTestSession refreshes a session and returns the response
+func refreshTestSession(t *testing.T, cfg *apiConfig, refreshToken string, want int) testLogin {
+	t.Helper()
+	w := testRequest(cfg.PostRefresh, "POST", refreshToken, nil)
+	if w.Code != want {
+		t.Fatalf("refresh: want status %d, got %d: %s", want, w.Code, w.Body.String())
+	}
+	refreshed := testLogin{}
+	if w.Code < 300 {
+		decodeResponse(t, w, &refreshed)
+	}
+	return refreshed
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	cfg := newTestConfig(t)
+	createTestUser(t, cfg, "a@example.com")
+	login := loginTestUser(t, cfg, "a@example.com", 200)
+
+	refreshed := refreshTestSession(t, cfg, login.RefreshToken, 200)
+	if refreshed.RefreshToken == "" || refreshed.RefreshToken == login.RefreshToken {
+		t.Fatalf("want a new refresh token, got %q", refreshed.RefreshToken)
+	}
+	if _, err := cfg.ValidateHeader(testRequestWithToken(refreshed.Token)); err != nil {
+		t.Errorf("new access token is invalid: %s", err)
+	}
+	refreshTestSession(t, cfg, refreshed.RefreshToken, 200)
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	cfg := newTestConfig(t)
+	createTestUser(t, cfg, "a@example.com")
+	login := loginTestUser(t, cfg, "a@example.com", 200)
+	refreshed := refreshTestSession(t, cfg, login.RefreshToken, 200)
+
+	// the old token is replayed, the session of both tokens ends
+	refreshTestSession(t, cfg, login.RefreshToken, 401)
+	refreshTestSession(t, cfg, refreshed.RefreshToken, 401)
+	if _, err := cfg.ValidateHeader(testRequestWithToken(refreshed.Token)); err == nil {
+		t.Error("access token of the revoked session is still valid")
+	}
+}
+
+func TestConcurrentRefreshRotatesOnce(t *testing.T) {
+	cfg := newTestConfig(t)
+	createTestUser(t, cfg, "a@example.com")
+	login := loginTestUser(t, cfg, "a@example.com", 200)
+
+	const attempts = 10
+	codes := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- testRequest(cfg.PostRefresh, "POST", login.RefreshToken, nil).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	succeeded := 0
+	for code := range codes {
+		if code == 200 {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("want exactly one successful refresh, got %d", succeeded)
+	}
+}
+
+func TestRefreshOfSuspendedUserKeepsSession(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := createTestUser(t, cfg, "a@example.com")
+	login := loginTestUser(t, cfg, "a@example.com", 200)
+	_, err := cfg.DB.SuspendUser(user.ID, Actor{Type: ActorSystem}, time.Hour, false, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshTestSession(t, cfg, login.RefreshToken, 403)
+
+	session, err := cfg.DB.GetSessionByRefresh(hashToken(login.RefreshToken))
+	if err != nil {
+		t.Fatalf("refresh of a suspended user changed the session: %s", err)
+	}
+	if len(session.RotatedTokenHashes) != 0 {
+		t.Errorf("want no rotated tokens, got %d", len(session.RotatedTokenHashes))
+	}
+}
+
+func TestRotatedTokenHashesAreCapped(t *testing.T) {
+	cfg := newTestConfig(t)
+	createTestUser(t, cfg, "a@example.com")
+	login := loginTestUser(t, cfg, "a@example.com", 200)
+
+	token := login.RefreshToken
+	for i := 0; i < maxRotatedTokenHashes+5; i++ {
+		token = refreshTestSession(t, cfg, token, 200).RefreshToken
+	}
+
+	session, err := cfg.DB.GetSessionByRefresh(hashToken(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(session.RotatedTokenHashes) != maxRotatedTokenHashes {
+		t.Errorf("want %d rotated tokens, got %d", maxRotatedTokenHashes, len(session.RotatedTokenHashes))
+	}
+}
+
+func TestAccessTokenNeedsSessionOfUser(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := createTestUser(t, cfg, "a@example.com")
+	createTestUser(t, cfg, "b@example.com")
+	// session 1 belongs to b
+	loginTestUser(t, cfg, "b@example.com", 200)
+
+	for _, sessionID := range []int{0, 1} {
+		token, err := MakeJWT(user.ID, sessionID, RoleUser, cfg.Keys, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cfg.ValidateHeader(testRequestWithToken(token)); err == nil {
+			t.Errorf("session %d: want the token of user a refused", sessionID)
+		}
+	}
+}
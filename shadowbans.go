@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+type shadowbanparameters struct {
+	Shadowbanned bool `json:"shadowbanned"`
+}
+
+// sets or lifts the shadowban of a user. Shadowbanned users keep posting
+// and see their own chirps, but nobody else does. Like suspensions it only
+// applies to users with a lower role than the admin.
+func (cfg *apiConfig) PutShadowban(w http.ResponseWriter, req *http.Request) {
+	actorid, target, ok := cfg.moderatedUser(w, req)
+	if !ok {
+		return
+	}
+	decoder := json.NewDecoder(req.Body)
+	params := shadowbanparameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "cannot decode json")
+		return
+	}
+
+	user, err := cfg.DB.SetShadowban(target.ID, userActor(req, actorid), params.Shadowbanned)
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, 404, "User does not exist")
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot set shadowban: %s\n", err.Error())
+		respondWithError(w, 500, "cannot set shadowban")
+		return
+	}
+	respondWithJSON(w, 200, shadowbanparameters{Shadowbanned: user.Shadowbanned})
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"math/bits"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const defaultSpamConfig = "./spam.json"
+
+// Decisions of the spam pipeline
+const (
+	SpamAccept = "accept"
+	SpamReview = "review"
+	SpamReject = "reject"
+)
+
+// SpamConfig holds the thresholds of the spam checks and the scores at
+// which a chirp is held for review or rejected
+type SpamConfig struct {
+	ReviewScore float64 `json:"review_score"`
+	RejectScore float64 `json:"reject_score"`
+	// Weights of the single checks by name, a check that triggers adds its weight
+	Weights map[string]float64 `json:"weights"`
+
+	DuplicateWindowSeconds     int `json:"duplicate_window_seconds"`
+	RateWindowSeconds          int `json:"rate_window_seconds"`
+	RateLimit                  int `json:"rate_limit"`
+	MaxLinks                   int `json:"max_links"`
+	MaxMentions                int `json:"max_mentions"`
+	NearDuplicateWindowSeconds int `json:"near_duplicate_window_seconds"`
+	// NearDuplicateDistance is the largest simhash distance of two near duplicates
+	NearDuplicateDistance int `json:"near_duplicate_distance"`
+}
+
+var defaultSpam = SpamConfig{
+	ReviewScore: 1,
+	RejectScore: 2,
+	Weights: map[string]float64{
+		"duplicate":      2,
+		"rate":           1,
+		"links":          1,
+		"mentions":       1,
+		"near_duplicate": 1,
+	},
+	DuplicateWindowSeconds:     24 * 60 * 60,
+	RateWindowSeconds:          60,
+	RateLimit:                  5,
+	MaxLinks:                   2,
+	MaxMentions:                5,
+	NearDuplicateWindowSeconds: 60 * 60,
+	NearDuplicateDistance:      6,
+}
+
+// copyDefaultSpam returns a copy of the defaults that can be changed
+// without changing defaultSpam
+func copyDefaultSpam() SpamConfig {
+	config := defaultSpam
+	config.Weights = maps.Clone(defaultSpam.Weights)
+	return config
+}
+
+// LoadSpamConfig reads the spam config file, settings missing in the file
+// keep their defaults
+func LoadSpamConfig(path string) (SpamConfig, error) {
+	config := copyDefaultSpam()
+	dat, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	weights := config.Weights
+	config.Weights = nil
+	err = json.Unmarshal(dat, &config)
+	if err != nil {
+		return copyDefaultSpam(), fmt.Errorf("cannot parse %s: %w", path, err)
+	}
+	for name, weight := range config.Weights {
+		weights[name] = weight
+	}
+	config.Weights = weights
+	return config, nil
+}
+
+// SpamCheck is one step of the spam pipeline. Check returns a reason when
+// the chirp looks like spam and an empty string otherwise.
+type SpamCheck interface {
+	Name() string
+	Check(db DBStructure, chirp Chirp, now time.Time) (reason string)
+}
+
+// SpamFinding is a check that triggered on a chirp
+type SpamFinding struct {
+	Check  string  `json:"check"`
+	Score  float64 `json:"score"`
+	Reason string  `json:"reason"`
+}
+
+type SpamResult struct {
+	Score    float64       `json:"score"`
+	Decision string        `json:"decision"`
+	Findings []SpamFinding `json:"findings"`
+}
+
+// SpamPipeline scores new chirps with all registered checks
+type SpamPipeline struct {
+	config SpamConfig
+	checks []SpamCheck
+}
+
+// NewSpamPipeline returns a pipeline with the default checks registered
+func NewSpamPipeline(config SpamConfig) *SpamPipeline {
+	p := SpamPipeline{config: config}
+	p.Register(duplicateCheck{window: time.Duration(config.DuplicateWindowSeconds) * time.Second})
+	p.Register(rateCheck{window: time.Duration(config.RateWindowSeconds) * time.Second, limit: config.RateLimit})
+	p.Register(linkCheck{max: config.MaxLinks})
+	p.Register(mentionCheck{max: config.MaxMentions})
+	p.Register(nearDuplicateCheck{
+		window:      time.Duration(config.NearDuplicateWindowSeconds) * time.Second,
+		maxDistance: config.NearDuplicateDistance,
+	})
+	return &p
+}
+
+// Register adds a check to the pipeline, its weight is looked up by name
+// and defaults to 1
+func (p *SpamPipeline) Register(check SpamCheck) {
+	p.checks = append(p.checks, check)
+}
+
+// Evaluate runs all checks against a chirp that is about to be created
+func (p *SpamPipeline) Evaluate(db DBStructure, chirp Chirp, now time.Time) SpamResult {
+	result := SpamResult{Decision: SpamAccept, Findings: []SpamFinding{}}
+	for _, check := range p.checks {
+		reason := check.Check(db, chirp, now)
+		if reason == "" {
+			continue
+		}
+		weight, ok := p.config.Weights[check.Name()]
+		if !ok {
+			weight = 1
+		}
+		result.Score += weight
+		result.Findings = append(result.Findings, SpamFinding{
+			Check:  check.Name(),
+			Score:  weight,
+			Reason: reason,
+		})
+	}
+	if result.Score >= p.config.RejectScore {
+		result.Decision = SpamReject
+	} else if result.Score >= p.config.ReviewScore {
+		result.Decision = SpamReview
+	}
+	return result
+}
+
+// moderationEvents turns the findings into moderation events, held
+// chirps are queued for moderators
+func (r SpamResult) moderationEvents(chirpid int, authorid int) []ModerationEvent {
+	events := []ModerationEvent{}
+	now := time.Now().UTC()
+	for _, finding := range r.Findings {
+		event := ModerationEvent{
+			ChirpID:   chirpid,
+			AuthorID:  authorid,
+			Rule:      "spam." + finding.Check,
+			Action:    r.Decision,
+			Term:      finding.Reason,
+			CreatedAt: now,
+		}
+		if r.Decision == SpamReview {
+			event.Status = ModerationPending
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// duplicateCheck flags a chirp the author already posted recently
+type duplicateCheck struct {
+	window time.Duration
+}
+
+func (c duplicateCheck) Name() string { return "duplicate" }
+
+func (c duplicateCheck) Check(db DBStructure, chirp Chirp, now time.Time) string {
+	body := normalizeSpamText(chirp.Body)
+	for _, other := range db.Chirps {
+		if other.AuthorID != chirp.AuthorID || now.Sub(other.CreatedAt) > c.window {
+			continue
+		}
+		if normalizeSpamText(other.Body) == body {
+			return fmt.Sprintf("same body as chirp %d", other.ID)
+		}
+	}
+	return ""
+}
+
+// rateCheck flags authors posting faster than the limit
+type rateCheck struct {
+	window time.Duration
+	limit  int
+}
+
+func (c rateCheck) Name() string { return "rate" }
+
+func (c rateCheck) Check(db DBStructure, chirp Chirp, now time.Time) string {
+	recent := 0
+	for _, other := range db.Chirps {
+		if other.AuthorID == chirp.AuthorID && now.Sub(other.CreatedAt) <= c.window {
+			recent++
+		}
+	}
+	if recent >= c.limit {
+		return fmt.Sprintf("%d chirps in %s", recent+1, c.window)
+	}
+	return ""
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+var mentionPattern = regexp.MustCompile(`(?:^|\s)@\w+`)
+
+// linkCheck flags chirps with too many links
+type linkCheck struct {
+	max int
+}
+
+func (c linkCheck) Name() string { return "links" }
+
+func (c linkCheck) Check(db DBStructure, chirp Chirp, now time.Time) string {
+	links := len(linkPattern.FindAllString(chirp.Body, -1))
+	if links > c.max {
+		return fmt.Sprintf("%d links", links)
+	}
+	return ""
+}
+
+// mentionCheck flags chirps with too many mentions
+type mentionCheck struct {
+	max int
+}
+
+func (c mentionCheck) Name() string { return "mentions" }
+
+func (c mentionCheck) Check(db DBStructure, chirp Chirp, now time.Time) string {
+	mentions := len(mentionPattern.FindAllString(chirp.Body, -1))
+	if mentions > c.max {
+		return fmt.Sprintf("%d mentions", mentions)
+	}
+	return ""
+}
+
+// nearDuplicateCheck flags chirps that are almost the same as a recent
+// chirp of another author, which is how spam campaigns across accounts look
+type nearDuplicateCheck struct {
+	window      time.Duration
+	maxDistance int
+}
+
+func (c nearDuplicateCheck) Name() string { return "near_duplicate" }
+
+func (c nearDuplicateCheck) Check(db DBStructure, chirp Chirp, now time.Time) string {
+	hash, ok := simhash(chirp.Body)
+	if !ok {
+		return ""
+	}
+	for _, other := range db.Chirps {
+		if other.AuthorID == chirp.AuthorID || now.Sub(other.CreatedAt) > c.window {
+			continue
+		}
+		otherHash, ok := simhash(other.Body)
+		if !ok {
+			continue
+		}
+		if bits.OnesCount64(hash^otherHash) <= c.maxDistance {
+			return fmt.Sprintf("near duplicate of chirp %d", other.ID)
+		}
+	}
+	return ""
+}
+
+// normalizeSpamText lower cases a body and collapses its whitespace
+func normalizeSpamText(body string) string {
+	return strings.Join(strings.Fields(strings.ToLower(body)), " ")
+}
+
+// stripPunctuation removes everything but letters, digits and spaces, so
+// near duplicates can't be told apart by punctuation
+func stripPunctuation(body string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) {
+			return r
+		}
+		return -1
+	}, body)
+}
+
+const shingleSize = 4
+
+// short bodies share too many shingles by chance to compare them
+const simhashMinLength = 20
+
+// simhash computes the 64 bit simhash over the character shingles of a
+// body. Short bodies have no simhash.
+func simhash(body string) (uint64, bool) {
+	runes := []rune(normalizeSpamText(stripPunctuation(body)))
+	if len(runes) < simhashMinLength {
+		return 0, false
+	}
+	var weights [64]int
+	for i := 0; i+shingleSize <= len(runes); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(string(runes[i : i+shingleSize])))
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+	var hash uint64
+	for bit := 0; bit < 64; bit++ {
+		if weights[bit] > 0 {
+			hash |= 1 << bit
+		}
+	}
+	return hash, true
+}
+
+// releases a chirp held for review so everyone can see it again
+func (cfg *apiConfig) PostApproveChirp(w http.ResponseWriter, req *http.Request) {
+	actorid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	sid := req.PathValue("chirpID")
+	chirpid, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "Chirp id could not be parsed")
+		return
+	}
+
+	chirp, err := cfg.DB.ApproveChirp(chirpid, userActor(req, actorid))
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, 404, "Chirp is not held for review")
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot approve chirp: %s\n", err.Error())
+		respondWithError(w, 500, "cannot approve chirp")
+		return
+	}
+	respondWithJSON(w, 200, chirp)
+}
+
+// hides a chirp held for review, only its author still sees it
+func (cfg *apiConfig) PostRejectChirp(w http.ResponseWriter, req *http.Request) {
+	actorid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	sid := req.PathValue("chirpID")
+	chirpid, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "Chirp id could not be parsed")
+		return
+	}
+
+	chirp, err := cfg.DB.RejectChirp(chirpid, userActor(req, actorid))
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, 404, "Chirp is not held for review")
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot reject chirp: %s\n", err.Error())
+		respondWithError(w, 500, "cannot reject chirp")
+		return
+	}
+	respondWithJSON(w, 200, chirp)
+}
+
+// heldChirp is a chirp held for review with the events that explain why
+type heldChirp struct {
+	Chirp
+	Events []ModerationEvent `json:"moderation_events"`
+}
+
+// lists the chirps held for review with their body and moderation events,
+// oldest first
+func (cfg *apiConfig) GetHeldChirps(w http.ResponseWriter, req *http.Request) {
+	db, err := cfg.DB.loadDB()
+	if err != nil {
+		respondWithError(w, 500, "cannot load db")
+		return
+	}
+	Chirps := []Chirp{}
+	for _, chirp := range db.Chirps {
+		if chirp.HeldForReview {
+			Chirps = append(Chirps, chirp)
+		}
+	}
+	held := []heldChirp{}
+	for _, chirp := range SortingChirps(Chirps, "asc") {
+		events := []ModerationEvent{}
+		for _, event := range db.ModerationEvents {
+			if event.ChirpID == chirp.ID {
+				events = append(events, event)
+			}
+		}
+		held = append(held, heldChirp{Chirp: chirp, Events: events})
+	}
+	respondWithJSON(w, 200, held)
+}
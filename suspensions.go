@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var ErrSuspended = errors.New("user is suspended")
+var ErrOutranked = errors.New("cannot moderate a user with the same or a higher role")
+
+type suspendparameters struct {
+	// DurationSeconds of the suspension, 0 suspends permanently
+	DurationSeconds int    `json:"duration_seconds,omitempty"`
+	HideChirps      bool   `json:"hide_chirps,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+}
+
+type suspensionResponse struct {
+	ID             int        `json:"id"`
+	Suspended      bool       `json:"suspended"`
+	SuspendedUntil *time.Time `json:"suspended_until,omitempty"`
+	HideChirps     bool       `json:"hide_chirps"`
+}
+
+// suspends a user for a duration or permanently
+func (cfg *apiConfig) PostSuspendUser(w http.ResponseWriter, req *http.Request) {
+	actorid, target, ok := cfg.moderatedUser(w, req)
+	if !ok {
+		return
+	}
+	decoder := json.NewDecoder(req.Body)
+	params := suspendparameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "cannot decode json")
+		return
+	}
+	if params.DurationSeconds < 0 {
+		respondWithError(w, 400, "duration_seconds must not be negative")
+		return
+	}
+
+	user, err := cfg.DB.SuspendUser(target.ID, userActor(req, actorid), time.Duration(params.DurationSeconds)*time.Second, params.HideChirps, params.Reason)
+	if err != nil {
+		fmt.Printf("cannot suspend user: %s\n", err.Error())
+		respondWithError(w, 500, "cannot suspend user")
+		return
+	}
+	respondWithJSON(w, 200, suspensionResponse{
+		ID:             user.ID,
+		Suspended:      user.Suspended,
+		SuspendedUntil: user.SuspendedUntil,
+		HideChirps:     user.HideChirps,
+	})
+}
+
+// lifts the suspension of a user
+func (cfg *apiConfig) PostReinstateUser(w http.ResponseWriter, req *http.Request) {
+	actorid, target, ok := cfg.moderatedUser(w, req)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.DB.ReinstateUser(target.ID, userActor(req, actorid))
+	if err != nil {
+		fmt.Printf("cannot reinstate user: %s\n", err.Error())
+		respondWithError(w, 500, "cannot reinstate user")
+		return
+	}
+	respondWithJSON(w, 200, suspensionResponse{
+		ID:         user.ID,
+		Suspended:  user.Suspended,
+		HideChirps: user.HideChirps,
+	})
+}
+
+// moderatedUser returns the acting moderator and the user in the path.
+// Moderators may only act on users with a lower role than their own.
+func (cfg *apiConfig) moderatedUser(w http.ResponseWriter, req *http.Request) (actorid int, target User, ok bool) {
+	actorid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return 0, User{}, false
+	}
+	actor, err := cfg.DB.GetUserbyID(actorid)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return 0, User{}, false
+	}
+	sid := req.PathValue("userID")
+	userid, err := strconv.Atoi(sid)
+	if err != nil {
+		respondWithError(w, 400, "user id could not be parsed")
+		return 0, User{}, false
+	}
+	target, err = cfg.DB.GetUserbyID(userid)
+	if err != nil {
+		respondWithError(w, 404, "User does not exist")
+		return 0, User{}, false
+	}
+	if roleLevels[target.RoleOrDefault()] >= roleLevels[actor.RoleOrDefault()] {
+		respondWithError(w, 403, "Forbidden - "+ErrOutranked.Error())
+		return 0, User{}, false
+	}
+	return actorid, target, true
+}
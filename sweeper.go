@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+const chirpSweepInterval = time.Minute
+
+// startChirpSweeper periodically removes expired ephemeral chirps from the
+// database. Expired chirps are already hidden by the handlers, the sweeper
+// only makes sure they don't stay on disk forever.
+func (cfg *apiConfig) startChirpSweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for range ticker.C {
+			purged, err := cfg.DB.PurgeExpiredChirps()
+			if err != nil {
+				log.Printf("error purging expired chirps: %s\n", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("purged %d expired chirps\n", purged)
+			}
+		}
+	}()
+}
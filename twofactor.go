@@ -0,0 +1,365 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters of RFC 6238 as understood by common authenticator apps
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// codes of the neighbouring time steps are accepted for clock drift
+	totpSkew = 1
+)
+
+const totpIssuer = "Chirpy"
+const recoveryCodeCount = 10
+const loginChallengeTTL = 5 * time.Minute
+const maxChallengeAttempts = 5
+
+var ErrInvalidCode = errors.New("invalid code")
+
+// TwoFactor holds the TOTP state of a user
+type TwoFactor struct {
+	// Secret is the base32 encoded shared secret, set once enrollment is confirmed
+	Secret string `json:"secret,omitempty"`
+	// PendingSecret waits for the first code of the authenticator app
+	PendingSecret string `json:"pending_secret,omitempty"`
+	// RecoveryCodeHashes are the SHA-256 hashes of the unused recovery codes
+	RecoveryCodeHashes []string `json:"recovery_code_hashes,omitempty"`
+	// LastStep is the last time step a code was accepted for, so a code
+	// can't be used twice
+	LastStep int64 `json:"last_step,omitempty"`
+}
+
+// Enabled reports whether logins need a second factor
+func (t TwoFactor) Enabled() bool {
+	return t.Secret != ""
+}
+
+// LoginChallenge is a login that passed the password check and waits for
+// the second factor. Only the hash of the challenge token is stored.
+type LoginChallenge struct {
+	UserID           int       `json:"user_id"`
+	DeviceName       string    `json:"device_name,omitempty"`
+	ExpiresInSeconds int       `json:"expires_in_seconds"`
+	Attempts         int       `json:"attempts"`
+	ExpiresAt        time.Time `json:"expires_at"`
+}
+
+type codeparameters struct {
+	Code string `json:"code"`
+}
+
+type disabletwofactorparameters struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type challengeparameters struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code,omitempty"`
+	RecoveryCode   string `json:"recovery_code,omitempty"`
+}
+
+// newTOTPSecret returns a random 160 bit secret, base32 encoded without padding
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// totpCode computes the code of a secret for a time step
+func totpCode(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	// dynamic truncation of RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod), nil
+}
+
+// verifyTOTP checks a code against the time steps around now and returns
+// the matched step. Steps up to lastStep were used before and don't count.
+func verifyTOTP(secret string, code string, now time.Time, lastStep int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// otpauthURI builds the key URI authenticator apps read from QR codes
+func otpauthURI(email string, secret string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", totpIssuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(totpDigits))
+	values.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(totpIssuer + ":" + email)
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// newRecoveryCodes returns fresh recovery codes in the form xxxxx-xxxxx
+func newRecoveryCodes() ([]string, error) {
+	codes := []string{}
+	for i := 0; i < recoveryCodeCount; i++ {
+		token, err := newToken()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, token[:5]+"-"+token[5:10])
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode accepts recovery codes with any case and spacing
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+}
+
+// starts the enrollment of the authenticated user, the secret is only
+// used for logins once a first code confirms it
+func (cfg *apiConfig) PostEnrollTwoFactor(w http.ResponseWriter, req *http.Request) {
+	userid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	user, err := cfg.DB.GetUserbyID(userid)
+	if err != nil {
+		respondWithError(w, 500, "cannot get user by id")
+		return
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		respondWithError(w, 500, "cannot generate secret")
+		return
+	}
+	err = cfg.DB.SetPendingTwoFactor(userid, secret)
+	if errors.Is(err, ErrAlreadyExists) {
+		respondWithError(w, 409, "two factor authentication is already enabled")
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot enroll two factor authentication: %s\n", err.Error())
+		respondWithError(w, 500, "cannot enroll two factor authentication")
+		return
+	}
+	type response struct {
+		Secret     string `json:"secret"`
+		OtpauthURI string `json:"otpauth_uri"`
+	}
+	respondWithJSON(w, 200, response{
+		Secret:     secret,
+		OtpauthURI: otpauthURI(user.Email, secret),
+	})
+}
+
+// confirms the enrollment with a first code and returns the recovery codes,
+// they are shown this one time only
+func (cfg *apiConfig) PostConfirmTwoFactor(w http.ResponseWriter, req *http.Request) {
+	userid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	params := codeparameters{}
+	err = json.NewDecoder(req.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "cannot decode json")
+		return
+	}
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		respondWithError(w, 500, "cannot generate recovery codes")
+		return
+	}
+	hashes := []string{}
+	for _, code := range codes {
+		hashes = append(hashes, hashToken(code))
+	}
+
+	err = cfg.DB.ConfirmTwoFactor(userid, params.Code, hashes)
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, 409, "no enrollment pending")
+		return
+	}
+	if errors.Is(err, ErrInvalidCode) {
+		respondWithError(w, 400, "invalid code")
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot confirm two factor authentication: %s\n", err.Error())
+		respondWithError(w, 500, "cannot confirm two factor authentication")
+		return
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	respondWithJSON(w, 200, response{RecoveryCodes: codes})
+}
+
+// turns two factor authentication off, which takes the password and a
+// current code or a recovery code
+func (cfg *apiConfig) DelTwoFactor(w http.ResponseWriter, req *http.Request) {
+	userid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	params := disabletwofactorparameters{}
+	err = json.NewDecoder(req.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "cannot decode json")
+		return
+	}
+	user, err := cfg.DB.GetUserbyID(userid)
+	if err != nil {
+		respondWithError(w, 500, "cannot get user by id")
+		return
+	}
+	if CheckPasswordHash(params.Password, user.Password) != nil {
+		respondWithError(w, 403, "Forbidden - password doesn't match")
+		return
+	}
+
+	err = cfg.DB.DisableTwoFactor(userid, params.Code)
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, 409, "two factor authentication is not enabled")
+		return
+	}
+	if errors.Is(err, ErrInvalidCode) {
+		respondWithError(w, 400, "invalid code")
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot disable two factor authentication: %s\n", err.Error())
+		respondWithError(w, 500, "cannot disable two factor authentication")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// respondWithChallenge answers a login with a correct password of a user
+// with two factor authentication by a short lived challenge token
+func (cfg *apiConfig) respondWithChallenge(w http.ResponseWriter, user User, params userparameters) {
+	token, err := newToken()
+	if err != nil {
+		respondWithError(w, 500, "cannot generate challenge")
+		return
+	}
+	err = cfg.DB.CreateLoginChallenge(hashToken(token), LoginChallenge{
+		UserID:           user.ID,
+		DeviceName:       params.DeviceName,
+		ExpiresInSeconds: params.ExpiresInSeconds,
+		ExpiresAt:        time.Now().UTC().Add(loginChallengeTTL),
+	})
+	if err != nil {
+		fmt.Printf("cannot create login challenge: %s\n", err.Error())
+		respondWithError(w, 500, "cannot create login challenge")
+		return
+	}
+	type response struct {
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		ChallengeToken    string `json:"challenge_token"`
+		ExpiresIn         int    `json:"expires_in"`
+	}
+	respondWithJSON(w, 200, response{
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+		ExpiresIn:         int(loginChallengeTTL.Seconds()),
+	})
+}
+
+// second step of a login with two factor authentication, takes the
+// challenge token and a code or a recovery code
+func (cfg *apiConfig) PostLoginTwoFactor(w http.ResponseWriter, req *http.Request) {
+	if retryAfter, locked := cfg.LoginThrottle.Locked(clientIP(req), time.Now().UTC()); locked {
+		respondLocked(w, retryAfter)
+		return
+	}
+	params := challengeparameters{}
+	err := json.NewDecoder(req.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "cannot decode json")
+		return
+	}
+	if params.ChallengeToken == "" || (params.Code == "") == (params.RecoveryCode == "") {
+		respondWithError(w, 400, "challenge_token and either code or recovery_code are required")
+		return
+	}
+
+	challenge, err := cfg.DB.AnswerLoginChallenge(hashToken(params.ChallengeToken), params.Code, params.RecoveryCode)
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, 401, "Unauthorized - challenge is invalid or expired")
+		return
+	}
+	if errors.Is(err, ErrLoginLocked) {
+		cfg.respondUserLocked(w, challenge.UserID)
+		return
+	}
+	if errors.Is(err, ErrInvalidCode) {
+		cfg.LoginThrottle.Fail(clientIP(req), time.Now().UTC())
+		respondWithError(w, 401, "Unauthorized - invalid code")
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot answer login challenge: %s\n", err.Error())
+		respondWithError(w, 500, "cannot answer login challenge")
+		return
+	}
+	user, err := cfg.DB.GetUserbyID(challenge.UserID)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized- cannot find user")
+		return
+	}
+	if user.IsSuspended(time.Now().UTC()) {
+		respondWithError(w, 403, "Forbidden - user is suspended")
+		return
+	}
+	cfg.completeLogin(w, req, user, userparameters{
+		ExpiresInSeconds: challenge.ExpiresInSeconds,
+		DeviceName:       challenge.DeviceName,
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// enableTestTwoFactor turns on TOTP for a user and returns the secret
+func enableTestTwoFactor(t *testing.T, cfg *apiConfig, user User) string {
+	t.Helper()
+	secret, err := newTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.DB.SetPendingTwoFactor(user.ID, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.DB.ConfirmTwoFactor(user.ID, testTOTPCode(t, secret, 0), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+// testTOTPCode returns the code of the time step offset steps from now
+func testTOTPCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	code, err := totpCode(secret, time.Now().Unix()/totpPeriod+offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// wrongTOTPCode returns a code no time step around now accepts
+func wrongTOTPCode(secret string) string {
+	for i := 0; ; i++ {
+		code := fmt.Sprintf("%0*d", totpDigits, i)
+		if _, ok := verifyTOTP(secret, code, time.Now(), 0); !ok {
+			return code
+		}
+	}
+}
+
+// createTestChallenge starts a login of user waiting for the second factor
+// and returns the challenge token
+func createTestChallenge(t *testing.T, cfg *apiConfig, user User) string {
+	t.Helper()
+	token, err := newToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.DB.CreateLoginChallenge(hashToken(token), LoginChallenge{
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(loginChallengeTTL),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// answerTestChallenge sends a code for a login challenge
+func answerTestChallenge(t *testing.T, cfg *apiConfig, challengeToken string, code string, want int) testLogin {
+	t.Helper()
+	w := testRequest(cfg.PostLoginTwoFactor, "POST", "", challengeparameters{ChallengeToken: challengeToken, Code: code})
+	if w.Code != want {
+		t.Fatalf("answer challenge: want status %d, got %d: %s", want, w.Code, w.Body.String())
+	}
+	login := testLogin{}
+	if w.Code < 300 {
+		decodeResponse(t, w, &login)
+	}
+	return login
+}
+
+func TestLoginWithTwoFactor(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := createTestUser(t, cfg, "a@example.com")
+	secret := enableTestTwoFactor(t, cfg, user)
+
+	login := loginTestUser(t, cfg, "a@example.com", 200)
+	if login.Token != "" || login.ChallengeToken == "" {
+		t.Fatalf("want only a challenge token, got %+v", login)
+	}
+	answerTestChallenge(t, cfg, login.ChallengeToken, wrongTOTPCode(secret), 401)
+	answered := answerTestChallenge(t, cfg, login.ChallengeToken, testTOTPCode(t, secret, 1), 200)
+	if answered.Token == "" || answered.RefreshToken == "" {
+		t.Fatalf("want tokens, got %+v", answered)
+	}
+	// the challenge is used up
+	answerTestChallenge(t, cfg, login.ChallengeToken, testTOTPCode(t, secret, 1), 401)
+}
+
+func TestWrongCodesLockAccountAcrossChallenges(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := createTestUser(t, cfg, "a@example.com")
+	secret := enableTestTwoFactor(t, cfg, user)
+	wrong := wrongTOTPCode(secret)
+
+	// a fresh challenge for every guess doesn't reset the count
+	for i := 0; i < accountFailureLimit; i++ {
+		answerTestChallenge(t, cfg, createTestChallenge(t, cfg, user), wrong, 401)
+	}
+
+	_, locked, err := cfg.DB.LoginLocked(user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !locked {
+		t.Error("want the account locked")
+	}
+	answerTestChallenge(t, cfg, createTestChallenge(t, cfg, user), testTOTPCode(t, secret, 1), 429)
+}
+
+func TestLockedAccountDropsChallenges(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := createTestUser(t, cfg, "a@example.com")
+	secret := enableTestTwoFactor(t, cfg, user)
+	wrong := wrongTOTPCode(secret)
+
+	pending := createTestChallenge(t, cfg, user)
+	guessing := createTestChallenge(t, cfg, user)
+	for i := 0; i < accountFailureLimit; i++ {
+		answerTestChallenge(t, cfg, guessing, wrong, 401)
+	}
+
+	// the right code of an older challenge doesn't get past the lock
+	answerTestChallenge(t, cfg, pending, testTOTPCode(t, secret, 1), 401)
+}
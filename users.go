@@ -1,11 +1,10 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
@@ -21,15 +20,62 @@ type userparameters struct {
 	Email            string `json:"email"`
 	Password         string `json:"password"`
 	ExpiresInSeconds int    `json:"expires_in_seconds,omitempty"`
+	// DeviceName labels the session created on login
+	DeviceName string `json:"device_name,omitempty"`
 }
 
 type User struct {
-	Email             string    `json:"email"`
-	ID                int       `json:"id"`
-	Password          string    `json:"password"`
-	RefreshToken      string    `json:"refresh_token"`
-	RefreshExpiration time.Time `json:"refesh_expiration"`
-	IsChirpyRed       bool      `json:"is_chirpy_red"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	ID            int    `json:"id"`
+	Password      string `json:"password"`
+	// RefreshToken and RefreshExpiration are only read to drop the refresh
+	// tokens of older database files
+	RefreshToken      string     `json:"refresh_token,omitempty"`
+	RefreshExpiration *time.Time `json:"refesh_expiration,omitempty"`
+	IsChirpyRed       bool       `json:"is_chirpy_red"`
+	PinnedChirpID     int        `json:"pinned_chirp_id,omitempty"`
+	ShowSensitive     bool       `json:"show_sensitive"`
+	Role              string     `json:"role,omitempty"`
+	Suspended         bool       `json:"suspended,omitempty"`
+	// SuspendedUntil is nil for permanent suspensions
+	SuspendedUntil *time.Time `json:"suspended_until,omitempty"`
+	// HideChirps hides the chirps of a suspended user until reinstated
+	HideChirps bool `json:"hide_chirps,omitempty"`
+	// Shadowbanned users must not find out about it, never return it to them
+	Shadowbanned   bool            `json:"shadowbanned,omitempty"`
+	KeywordFilters []KeywordFilter `json:"keyword_filters,omitempty"`
+	LastFilterID   int             `json:"last_filter_id,omitempty"`
+}
+
+// Suspend suspends the user for the given duration, 0 suspends permanently
+func (u *User) Suspend(duration time.Duration, hideChirps bool) {
+	u.Suspended = true
+	u.SuspendedUntil = nil
+	u.HideChirps = hideChirps
+	if duration > 0 {
+		until := time.Now().UTC().Add(duration)
+		u.SuspendedUntil = &until
+	}
+}
+
+// Reinstate lifts a suspension
+func (u *User) Reinstate() {
+	u.Suspended = false
+	u.SuspendedUntil = nil
+	u.HideChirps = false
+}
+
+// IsSuspended reports whether the user is suspended at the given time
+func (u User) IsSuspended(now time.Time) bool {
+	if !u.Suspended {
+		return false
+	}
+	return u.SuspendedUntil == nil || u.SuspendedUntil.After(now)
+}
+
+type preferenceparameters struct {
+	ShowSensitive bool `json:"show_sensitive"`
 }
 
 func (cfg *apiConfig) PostUsers(w http.ResponseWriter, req *http.Request) {
@@ -40,6 +86,15 @@ func (cfg *apiConfig) PostUsers(w http.ResponseWriter, req *http.Request) {
 		respondWithError(w, 500, err.Error())
 		return
 	}
+	params.Email = NormalizeEmail(params.Email)
+	if err := ValidateEmail(params.Email); err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if err := cfg.PasswordPolicy.Validate(params.Password); err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
 
 	pw, err := HashPassword(params.Password)
 	if err != nil {
@@ -48,34 +103,44 @@ func (cfg *apiConfig) PostUsers(w http.ResponseWriter, req *http.Request) {
 	}
 
 	newUser, err := cfg.DB.CreateUser(params.Email, pw)
+	if errors.Is(err, ErrAlreadyExists) {
+		respondWithError(w, 409, "email is already in use")
+		return
+	}
 	if err != nil {
 		fmt.Printf("error: %s", err.Error())
 		respondWithError(w, 500, err.Error())
 		return
 	}
+	// the account is usable right away, a failed mail can be resent later
+	err = cfg.sendVerification(newUser)
+	if err != nil {
+		fmt.Printf("cannot send verification mail: %s\n", err.Error())
+	}
 
 	respondWithJSON(w, 201, newUser)
 }
 
 func (cfg *apiConfig) PostRevoke(w http.ResponseWriter, req *http.Request) {
-	//Revoke the token in the database that matches the token that was passed in
-	// the header of the request. Respond with a 200 status code.
-	// --> revoke means to delete the request token i assume.... might need to check with Boots
-	refreshtoken := req.Header.Get("Authorization")
-	refreshtoken = strings.Split(refreshtoken, "Bearer ")[1]
+	// revoking a refresh token ends the session it belongs to
+	refreshtoken, err := bearerToken(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
 
-	user, err := cfg.DB.GetUserbyRefresh(refreshtoken)
+	session, err := cfg.DB.GetSessionByRefresh(hashToken(refreshtoken))
 	if err != nil {
-		fmt.Printf("could not find user id in db via refresh token: %s", err.Error())
+		fmt.Printf("could not find session in db via refresh token: %s", err.Error())
 		respondWithError(w, 401, "cannot get user via refresh token")
 		return
 	}
-	if user.RefreshExpiration.Before(time.Now().UTC()) {
-		fmt.Printf("Refresh Timer expired: %v", user.RefreshExpiration)
+	if session.ExpiresAt.Before(time.Now().UTC()) {
+		fmt.Printf("Refresh Timer expired: %v", session.ExpiresAt)
 		respondWithError(w, 401, "Unauthorized - expired refresh token")
 		return
 	}
-	err = cfg.DB.DelRefreshToken(user.ID)
+	err = cfg.DB.DelSession(session.UserID, session.ID)
 	if err != nil {
 		fmt.Printf("error revoking token: %s", err.Error())
 		respondWithError(w, 503, "error revoking token")
@@ -83,29 +148,49 @@ func (cfg *apiConfig) PostRevoke(w http.ResponseWriter, req *http.Request) {
 	}
 
 }
-
 func (cfg *apiConfig) PostRefresh(w http.ResponseWriter, req *http.Request) {
-	refreshtoken := req.Header.Get("Authorization")
-	refreshtoken = strings.Split(refreshtoken, "Bearer ")[1]
+	refreshtoken, err := bearerToken(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
 
-	user, err := cfg.DB.GetUserbyRefresh(refreshtoken)
+	// every refresh hands out a new refresh token and retires the old one
+	newtoken, err := GetRefreshToken()
 	if err != nil {
-		fmt.Printf("could not find user id in db via refresh token: %s", err.Error())
-		respondWithError(w, 401, "cannot get user via refresh token")
+		fmt.Printf("cannot generate refresh token: %s\n", err.Error())
+		respondWithError(w, 500, "cannot generate refresh token")
 		return
 	}
-	if user.RefreshExpiration.Before(time.Now().UTC()) {
-		fmt.Printf("Refresh Timer expired: %v", user.RefreshExpiration)
-		respondWithError(w, 401, "Unauthorized - expired refresh token")
+	actor := Actor{Type: ActorSystem, RequestID: requestID(req)}
+	session, err := cfg.DB.RotateSession(hashToken(refreshtoken), hashToken(newtoken), clientIP(req), req.UserAgent(), actor)
+	if errors.Is(err, ErrRefreshTokenReused) {
+		log.Printf("security: reused refresh token of session %d of user %d from %s, session revoked\n", session.ID, session.UserID, clientIP(req))
+		respondWithError(w, 401, "Unauthorized - refresh token was already used")
+		return
+	}
+	if errors.Is(err, ErrSuspended) {
+		respondWithError(w, 403, "Forbidden - user is suspended")
+		return
+	}
+	if err != nil {
+		fmt.Printf("could not rotate refresh token: %s\n", err.Error())
+		respondWithError(w, 401, "Unauthorized - invalid or expired refresh token")
+		return
+	}
+	user, err := cfg.DB.GetUserbyID(session.UserID)
+	if err != nil {
+		respondWithError(w, 401, "cannot get user via refresh token")
 		return
 	}
 
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	// generate new access tken and send response
-	token, err := MakeJWT(user.ID, cfg.JWT_SECRET, time.Duration(60*60)*time.Second)
+	token, err := MakeJWT(user.ID, session.ID, user.Role, cfg.Keys, time.Duration(60*60)*time.Second)
 	if err != nil {
 		fmt.Printf("cannot Make JWT: %v", err.Error())
 		respondWithError(w, 401, "cannot Make JWT")
@@ -113,28 +198,65 @@ func (cfg *apiConfig) PostRefresh(w http.ResponseWriter, req *http.Request) {
 	}
 
 	respondWithJSON(w, 200, response{
-		Token: token,
+		Token:        token,
+		RefreshToken: newtoken,
 	})
 
 }
 
 func (cfg *apiConfig) ValidateHeader(req *http.Request) (userid int, err error) {
+	userid, _, err = cfg.ValidateHeaderClaims(req)
+	return userid, err
+}
+
+// ValidateHeaderClaims validates the access token of a request and returns
+// the user id and the claims of the token
+func (cfg *apiConfig) ValidateHeaderClaims(req *http.Request) (userid int, claims ChirpyClaims, err error) {
 	token := req.Header.Get("Authorization")
 	if token == "" {
-		return 0, errors.New("cannot find Authorization Header")
+		return 0, ChirpyClaims{}, errors.New("cannot find Authorization Header")
+	}
+	token, found := strings.CutPrefix(token, "Bearer ")
+	if !found {
+		return 0, ChirpyClaims{}, errors.New("malformed Authorization Header")
 	}
-	token = strings.Split(token, "Bearer ")[1]
 
-	suserid, err := ValidateJWT(token, cfg.JWT_SECRET)
+	claims, err = ValidateJWT(token, cfg.Keys)
 	if err != nil {
-		return 0, err
+		return 0, ChirpyClaims{}, err
 	}
-	userid, err = strconv.Atoi(suserid)
+	userid, err = strconv.Atoi(claims.Subject)
 	if err != nil {
 		fmt.Printf("cannot convert userid: %v\n", err)
-		return 0, err
+		return 0, ChirpyClaims{}, err
+	}
+	// tokens issued before a suspension stay valid otherwise
+	user, err := cfg.DB.GetUserbyID(userid)
+	if err != nil {
+		return 0, ChirpyClaims{}, err
 	}
-	return userid, nil
+	if user.IsSuspended(time.Now().UTC()) {
+		return 0, ChirpyClaims{}, ErrSuspended
+	}
+	// access tokens of a revoked session stop working right away. Every
+	// token names its session, one without can't be checked.
+	exists, err := cfg.DB.SessionExists(claims.SessionID, userid)
+	if err != nil {
+		return 0, ChirpyClaims{}, err
+	}
+	if !exists {
+		return 0, ChirpyClaims{}, ErrSessionRevoked
+	}
+	return userid, claims, nil
+}
+
+// OptionalViewer returns the id of the authenticated caller or 0 for
+// anonymous requests without an Authorization header
+func (cfg *apiConfig) OptionalViewer(req *http.Request) (userid int, err error) {
+	if req.Header.Get("Authorization") == "" {
+		return 0, nil
+	}
+	return cfg.ValidateHeader(req)
 }
 
 func (cfg *apiConfig) PutUsers(w http.ResponseWriter, req *http.Request) {
@@ -143,6 +265,7 @@ func (cfg *apiConfig) PutUsers(w http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		fmt.Printf("error validating Auth Header: %s\n", err.Error())
 		respondWithError(w, 401, "cannot get user by id:")
+		return
 	}
 
 	user, err := cfg.DB.GetUserbyID(id)
@@ -158,6 +281,15 @@ func (cfg *apiConfig) PutUsers(w http.ResponseWriter, req *http.Request) {
 		respondWithError(w, 500, err.Error())
 		return
 	}
+	params.Email = NormalizeEmail(params.Email)
+	if err := ValidateEmail(params.Email); err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if err := cfg.PasswordPolicy.Validate(params.Password); err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
 
 	pw, err := HashPassword(params.Password)
 	if err != nil {
@@ -165,37 +297,68 @@ func (cfg *apiConfig) PutUsers(w http.ResponseWriter, req *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "cannot hash password")
 		return
 	}
-	newUser := User{
-		Email:             params.Email,
-		Password:          pw,
-		ID:                id,
-		RefreshToken:      user.RefreshToken,
-		RefreshExpiration: user.RefreshExpiration,
-		IsChirpyRed:       user.IsChirpyRed,
+	// start from the stored user so fields the client can't change survive the update
+	newUser := user
+	newUser.Email = params.Email
+	newUser.Password = pw
+	emailChanged := newUser.Email != NormalizeEmail(user.Email)
+	if emailChanged {
+		newUser.EmailVerified = false
 	}
 	err = cfg.DB.UpdateUser(id, newUser)
+	if errors.Is(err, ErrAlreadyExists) {
+		respondWithError(w, 409, "email is already in use")
+		return
+	}
 	if err != nil {
 		fmt.Printf("could not update user. error: %v\n", err.Error())
 		respondWithError(w, 500, err.Error())
 		return
 	}
+	if emailChanged {
+		err = cfg.sendVerification(newUser)
+		if err != nil {
+			fmt.Printf("cannot send verification mail: %s\n", err.Error())
+		}
+	}
 	type response struct {
 		User
 	}
 	responseUser := response{
-		User: User{
-			Email:             params.Email,
-			ID:                user.ID,
-			RefreshToken:      user.RefreshToken,
-			RefreshExpiration: user.RefreshExpiration,
-			IsChirpyRed:       user.IsChirpyRed,
-		},
+		User: newUser,
 	}
+	responseUser.Password = ""
+	responseUser.Shadowbanned = false
 
 	respondWithJSON(w, 200, responseUser)
 
 }
 
+// updates the display preferences of the authenticated user
+func (cfg *apiConfig) PutPreferences(w http.ResponseWriter, req *http.Request) {
+	id, err := cfg.ValidateHeader(req)
+	if err != nil {
+		fmt.Printf("error validating Auth Header: %s\n", err.Error())
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	decoder := json.NewDecoder(req.Body)
+	params := preferenceparameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	err = cfg.DB.SetShowSensitive(id, params.ShowSensitive)
+	if err != nil {
+		fmt.Printf("could not update preferences. error: %v\n", err.Error())
+		respondWithError(w, 500, "cannot update preferences")
+		return
+	}
+	respondWithJSON(w, 200, params)
+}
+
 func (cfg *apiConfig) PostLogin(w http.ResponseWriter, req *http.Request) {
 	decoder := json.NewDecoder(req.Body)
 	params := userparameters{}
@@ -204,16 +367,48 @@ func (cfg *apiConfig) PostLogin(w http.ResponseWriter, req *http.Request) {
 		respondWithError(w, 500, err.Error())
 		return
 	}
+	ip := clientIP(req)
+	now := time.Now().UTC()
+	if retryAfter, locked := cfg.LoginThrottle.Locked(ip, now); locked {
+		respondLocked(w, retryAfter)
+		return
+	}
+	// unknown emails and wrong passwords look the same, in the response,
+	// in the lockout and in the time it takes
 	user, err := cfg.DB.GetUserbyMail(params.Email)
+	passwordHash := user.Password
 	if err != nil {
 		fmt.Printf("error cannot find user %s\n", err.Error())
-		respondWithError(w, 401, "Unauthorized- cannot find user")
+		passwordHash = dummyPasswordHash
+	}
+	retryAfter, locked := cfg.LoginThrottle.EmailLocked(params.Email, now)
+	if user.ID != 0 {
+		retryAfter, locked, err = cfg.DB.LoginLocked(user.Email)
+		if err != nil {
+			respondWithError(w, 500, "cannot load db")
+			return
+		}
+	}
+	passwordErr := CheckPasswordHash(params.Password, passwordHash)
+	if locked {
+		respondLocked(w, retryAfter)
 		return
 	}
-	err = CheckPasswordHash(params.Password, user.Password)
-	if err != nil {
-		fmt.Printf("error Password doesn't match: %s\n", err.Error())
-		respondWithError(w, 401, "Unauthorized- passwords don't match")
+	if passwordErr != nil || user.ID == 0 {
+		cfg.LoginThrottle.Fail(ip, now)
+		if user.ID == 0 {
+			cfg.LoginThrottle.FailEmail(params.Email, now)
+		} else {
+			err = cfg.DB.RecordLoginFailure(user.ID)
+			if err != nil {
+				fmt.Printf("cannot record failed login: %s\n", err.Error())
+			}
+		}
+		respondLoginFailed(w)
+		return
+	}
+	if user.IsSuspended(time.Now().UTC()) {
+		respondWithError(w, 403, "Forbidden - user is suspended")
 		return
 	}
 	defaultExpiration := 60 * 60
@@ -222,22 +417,59 @@ func (cfg *apiConfig) PostLogin(w http.ResponseWriter, req *http.Request) {
 	} else if params.ExpiresInSeconds > defaultExpiration {
 		params.ExpiresInSeconds = defaultExpiration
 	}
-	token, err := MakeJWT(user.ID, cfg.JWT_SECRET, time.Duration(params.ExpiresInSeconds)*time.Second)
+	if len(params.DeviceName) > maxDeviceNameLength {
+		respondWithError(w, 400, "Device name is too long")
+		return
+	}
+	// with two factor authentication the password only earns a challenge
+	twoFactor, err := cfg.DB.TwoFactorEnabled(user.ID)
 	if err != nil {
-		fmt.Printf("cannot Make JWT: %v", err.Error())
-		respondWithError(w, 401, "cannot Make JWT")
+		respondWithError(w, 500, "cannot load db")
+		return
+	}
+	if twoFactor {
+		cfg.respondWithChallenge(w, user, params)
 		return
 	}
+	cfg.completeLogin(w, req, user, params)
+}
+
+// completeLogin creates a session for an authenticated user and responds
+// with the access and refresh token. Failed logins of the user are only
+// forgotten here, once every factor was checked.
+func (cfg *apiConfig) completeLogin(w http.ResponseWriter, req *http.Request, user User, params userparameters) {
 	//expires_in_seconds is an optional parameter. If it's specified by the client, use it as the expiration time. If it's not specified,
 	// use a default expiration time of 24 hours. If the client specified a number over 24 hours, use 24 hours as the expiration time.
 
-	refresh_token := GetRefreshToken()
+	refresh_token, err := GetRefreshToken()
+	if err != nil {
+		fmt.Printf("cannot generate refresh token: %s\n", err.Error())
+		respondWithError(w, 500, "cannot generate refresh token")
+		return
+	}
 
-	err = cfg.DB.SetRefreshToken(user.ID, refresh_token, time.Duration(time.Hour*24*60))
+	session, err := cfg.DB.CreateSession(Session{
+		UserID:           user.ID,
+		RefreshTokenHash: hashToken(refresh_token),
+		DeviceName:       params.DeviceName,
+		IP:               clientIP(req),
+		UserAgent:        req.UserAgent(),
+		ExpiresAt:        time.Now().UTC().Add(refreshTokenTTL),
+	})
 	if err != nil {
 		respondWithError(w, 401, "cannot update refresh token in db")
 		return
 	}
+	token, err := MakeJWT(user.ID, session.ID, user.Role, cfg.Keys, time.Duration(params.ExpiresInSeconds)*time.Second)
+	if err != nil {
+		fmt.Printf("cannot Make JWT: %v", err.Error())
+		respondWithError(w, 401, "cannot Make JWT")
+		return
+	}
+	err = cfg.DB.ClearLoginFailures(user.Email)
+	if err != nil {
+		fmt.Printf("cannot clear failed logins: %s\n", err.Error())
+	}
 
 	type returnUser struct {
 		ID           int    `json:"id"`
@@ -245,6 +477,7 @@ func (cfg *apiConfig) PostLogin(w http.ResponseWriter, req *http.Request) {
 		Token        string `json:"token"`
 		RefreshToken string `json:"refresh_token"`
 		IsChirpyRed  bool   `json:"is_chirpy_red"`
+		Role         string `json:"role"`
 	}
 
 	rUser := returnUser{
@@ -253,25 +486,15 @@ func (cfg *apiConfig) PostLogin(w http.ResponseWriter, req *http.Request) {
 		Token:        token,
 		RefreshToken: refresh_token,
 		IsChirpyRed:  user.IsChirpyRed,
+		Role:         user.RoleOrDefault(),
 	}
 
 	respondWithJSON(w, 200, rUser)
 }
 
-// Generates a refresh_token
-func GetRefreshToken() (refresh_token string) {
-	c := 256
-	b := make([]byte, c)
-	inttoken, err := rand.Read(b)
-	if err != nil {
-		fmt.Println("error:", err)
-		return
-	}
-	stringtoken := strconv.Itoa(inttoken)
-	src := []byte(stringtoken)
-	refresh_token = hex.EncodeToString(src)
-
-	return refresh_token
+// Generates a refresh_token with 256 bits of entropy
+func GetRefreshToken() (refresh_token string, err error) {
+	return newToken()
 }
 
 // ErrNoAuthHeaderIncluded -
@@ -291,43 +514,44 @@ func CheckPasswordHash(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
+// ChirpyClaims are the claims of the access tokens issued by chirpy
+type ChirpyClaims struct {
+	Role string `json:"role"`
+	// SessionID is the session the token was issued for
+	SessionID int `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
 // MakeJWT -
-func MakeJWT(userID int, tokenSecret string, expiresIn time.Duration) (string, error) {
-	signingKey := []byte(tokenSecret)
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   fmt.Sprintf("%d", userID),
+func MakeJWT(userID int, sessionID int, role string, keys *KeySet, expiresIn time.Duration) (string, error) {
+	return keys.Sign(ChirpyClaims{
+		Role:      role,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   fmt.Sprintf("%d", userID),
+		},
 	})
-	return token.SignedString(signingKey)
 }
 
 // ValidateJWT -
-func ValidateJWT(tokenString, tokenSecret string) (string, error) {
-	claimsStruct := jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(
+func ValidateJWT(tokenString string, keys *KeySet) (ChirpyClaims, error) {
+	claimsStruct := ChirpyClaims{}
+	_, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		keys.keyfunc,
+		jwt.WithValidMethods([]string{AlgEdDSA, AlgRS256, jwt.SigningMethodHS256.Alg()}),
 	)
 	if err != nil {
-		return "", err
-	}
-
-	userIDString, err := token.Claims.GetSubject()
-	if err != nil {
-		return "", err
+		return ChirpyClaims{}, err
 	}
 
-	issuer, err := token.Claims.GetIssuer()
-	if err != nil {
-		return "", err
-	}
-	if issuer != string("chirpy") {
-		return "", errors.New("invalid issuer")
+	if claimsStruct.Issuer != string("chirpy") {
+		return ChirpyClaims{}, errors.New("invalid issuer")
 	}
 
-	return userIDString, nil
+	return claimsStruct, nil
 }
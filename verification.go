@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const verificationTTL = 24 * time.Hour
+
+// actions that can be restricted for users with an unverified email
+const (
+	RestrictChirps  = "chirps"
+	RestrictReports = "reports"
+	RestrictFollows = "follows"
+)
+
+// restrictions used when UNVERIFIED_RESTRICTIONS is not set and mails
+// are delivered, without a mail transport nobody could verify
+const defaultUnverifiedRestrictions = RestrictChirps
+
+// EmailVerification is a pending verification of an email address. Only
+// the hash of the token is stored.
+type EmailVerification struct {
+	UserID    int       `json:"user_id"`
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// verifyExistingUsers marks the users of database files from before email
+// verification as verified, so the restrictions don't lock out accounts
+// that never got a verification mail. Users with a pending verification
+// signed up afterwards and stay unverified. It runs once per database.
+func (dbs *DBStructure) verifyExistingUsers() {
+	if dbs.VerificationMigrated {
+		return
+	}
+	pending := map[int]bool{}
+	for _, verification := range dbs.EmailVerifications {
+		pending[verification.UserID] = true
+	}
+	for id, user := range dbs.Users {
+		if pending[id] {
+			continue
+		}
+		user.EmailVerified = true
+		dbs.Users[id] = user
+	}
+	dbs.VerificationMigrated = true
+}
+
+type verifyparameters struct {
+	Token string `json:"token"`
+}
+
+// LoadUnverifiedRestrictions reads the comma separated list of actions
+// unverified users can't take from UNVERIFIED_RESTRICTIONS, "none"
+// lifts all restrictions. Unless mails are delivered there are no
+// restrictions by default and configuring some is an error, since
+// verification tokens never reach the users.
+func LoadUnverifiedRestrictions(mailDelivered bool) (map[string]bool, error) {
+	value, ok := os.LookupEnv("UNVERIFIED_RESTRICTIONS")
+	if !ok {
+		value = defaultUnverifiedRestrictions
+		if !mailDelivered {
+			log.Println("no mail transport configured, unverified users are not restricted")
+			value = "none"
+		}
+	}
+	restrictions := map[string]bool{}
+	for _, action := range strings.Split(value, ",") {
+		action = strings.TrimSpace(action)
+		if action != "" && action != "none" {
+			restrictions[action] = true
+		}
+	}
+	if len(restrictions) > 0 && !mailDelivered {
+		return nil, errors.New("UNVERIFIED_RESTRICTIONS needs a mail transport, set MAIL_SMTP_ADDR or MAIL_FILE")
+	}
+	return restrictions, nil
+}
+
+// requireVerified refuses the request with 403 when the action is
+// restricted and the email of the user is not verified yet
+func (cfg *apiConfig) requireVerified(w http.ResponseWriter, userid int, action string) bool {
+	if !cfg.UnverifiedRestrictions[action] {
+		return true
+	}
+	user, err := cfg.DB.GetUserbyID(userid)
+	if err != nil {
+		respondWithError(w, 500, "cannot get user by id")
+		return false
+	}
+	if !user.EmailVerified {
+		respondWithError(w, 403, "Forbidden - verify your email first")
+		return false
+	}
+	return true
+}
+
+// hashToken returns the hex encoded SHA-256 of a token, tokens sent to
+// users are only stored hashed
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newToken returns a random url safe token with 256 bits of entropy
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// sendVerification issues a new verification token for the current email
+// of the user and mails it. Earlier tokens of the user become invalid.
+func (cfg *apiConfig) sendVerification(user User) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	err = cfg.DB.CreateVerification(hashToken(token), user.ID, user.Email, verificationTTL)
+	if err != nil {
+		return err
+	}
+	link := cfg.BaseURL + "/api/users/verify?token=" + url.QueryEscape(token)
+	return cfg.Mailer.Send(Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email",
+		Body: fmt.Sprintf("Confirm your email address by opening\n\n%s\n\nThe link is valid for %s.\n",
+			link, verificationTTL),
+	})
+}
+
+// confirms an email with the token from the verification mail, the token
+// is read from the query or a json body
+func (cfg *apiConfig) PostVerifyEmail(w http.ResponseWriter, req *http.Request) {
+	token := req.URL.Query().Get("token")
+	if token == "" && req.Method == http.MethodPost {
+		params := verifyparameters{}
+		err := json.NewDecoder(req.Body).Decode(&params)
+		if err != nil {
+			respondWithError(w, 400, "cannot decode json")
+			return
+		}
+		token = params.Token
+	}
+	if token == "" {
+		respondWithError(w, 400, "token is missing")
+		return
+	}
+
+	user, err := cfg.DB.VerifyEmail(hashToken(token))
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, 400, "token is invalid or expired")
+		return
+	}
+	if err != nil {
+		fmt.Printf("cannot verify email: %s\n", err.Error())
+		respondWithError(w, 500, "cannot verify email")
+		return
+	}
+	type response struct {
+		ID            int    `json:"id"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	respondWithJSON(w, 200, response{
+		ID:            user.ID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+	})
+}
+
+// sends a new verification mail to the authenticated user
+func (cfg *apiConfig) PostResendVerification(w http.ResponseWriter, req *http.Request) {
+	userid, err := cfg.ValidateHeader(req)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	user, err := cfg.DB.GetUserbyID(userid)
+	if err != nil {
+		respondWithError(w, 500, "cannot get user by id")
+		return
+	}
+	if user.EmailVerified {
+		respondWithError(w, 409, "email is already verified")
+		return
+	}
+	err = cfg.sendVerification(user)
+	if err != nil {
+		fmt.Printf("cannot send verification mail: %s\n", err.Error())
+		respondWithError(w, 500, "cannot send verification mail")
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLoadUnverifiedRestrictions(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		set       bool
+		delivered bool
+		want      []string
+		wantErr   bool
+	}{
+		{name: "default with mail", delivered: true, want: []string{RestrictChirps}},
+		{name: "default without mail", want: nil},
+		{name: "none without mail", value: "none", set: true, want: nil},
+		{name: "configured with mail", value: "chirps, follows", set: true, delivered: true, want: []string{RestrictChirps, RestrictFollows}},
+		{name: "configured without mail", value: "chirps", set: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		if tt.set {
+			t.Setenv("UNVERIFIED_RESTRICTIONS", tt.value)
+		} else {
+			t.Setenv("UNVERIFIED_RESTRICTIONS", "")
+			os.Unsetenv("UNVERIFIED_RESTRICTIONS")
+		}
+		restrictions, err := LoadUnverifiedRestrictions(tt.delivered)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: want error %v, got %v", tt.name, tt.wantErr, err)
+			continue
+		}
+		if len(restrictions) != len(tt.want) {
+			t.Errorf("%s: want %v, got %v", tt.name, tt.want, restrictions)
+		}
+		for _, action := range tt.want {
+			if !restrictions[action] {
+				t.Errorf("%s: want %s restricted, got %v", tt.name, action, restrictions)
+			}
+		}
+	}
+}
+
+func TestMailDelivered(t *testing.T) {
+	if mailDelivered(LogMailer{}) {
+		t.Error("want a log without bodies to deliver nothing")
+	}
+	if !mailDelivered(LogMailer{ShowBody: true}) {
+		t.Error("want a log with bodies to deliver")
+	}
+	if !mailDelivered(NewFileMailer("mails.txt", "chirpy@localhost")) {
+		t.Error("want the file mailer to deliver")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// Visibility levels of a chirp. Chirps stored before visibility existed
+// have an empty visibility and are treated as public.
+const (
+	VisibilityPublic    = "public"
+	VisibilityFollowers = "followers"
+	VisibilityPrivate   = "private"
+)
+
+var ErrInvalidVisibility = errors.New("visibility must be one of public, followers or private")
+
+// validVisibility normalizes the visibility requested by a client,
+// an empty visibility defaults to public
+func validVisibility(visibility string) (string, error) {
+	switch visibility {
+	case "":
+		return VisibilityPublic, nil
+	case VisibilityPublic, VisibilityFollowers, VisibilityPrivate:
+		return visibility, nil
+	}
+	return "", ErrInvalidVisibility
+}
+
+// CanView reports whether the user with id viewerid may see a chirp.
+// A viewerid of 0 is an anonymous caller. Every read path has to go through
+// CanView, chirps that can't be seen are answered with 404 like missing ones.
+func (dbs DBStructure) CanView(viewerid int, chirp Chirp, now time.Time) bool {
+	if chirp.Expired(now) {
+		return false
+	}
+	if viewerid != 0 && chirp.AuthorID == viewerid {
+		return true
+	}
+	if chirp.Hidden || chirp.HeldForReview {
+		return false
+	}
+	author := dbs.Users[chirp.AuthorID]
+	if author.Shadowbanned {
+		return false
+	}
+	if author.HideChirps && author.IsSuspended(now) {
+		return false
+	}
+	switch chirp.Visibility {
+	case VisibilityFollowers:
+		return dbs.IsFollowing(viewerid, chirp.AuthorID)
+	case VisibilityPrivate:
+		return false
+	}
+	return true
+}
+
+// IsFollowing reports whether follower follows followee
+func (dbs DBStructure) IsFollowing(follower, followee int) bool {
+	if follower == 0 {
+		return false
+	}
+	return contains(dbs.Follows[follower], followee)
+}
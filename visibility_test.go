@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCanView(t *testing.T) {
+	now := time.Now().UTC()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	dbs := newTestDBStructure(
+		User{ID: 1},
+		User{ID: 2},
+		User{ID: 3},
+		User{ID: 4, Shadowbanned: true},
+		User{ID: 5, Suspended: true, SuspendedUntil: &future, HideChirps: true},
+		User{ID: 6, Suspended: true, SuspendedUntil: &future},
+		User{ID: 7, Suspended: true, SuspendedUntil: &past, HideChirps: true},
+	)
+	// user 2 follows user 1
+	dbs.Follows[2] = []int{1}
+
+	tests := []struct {
+		name     string
+		viewerid int
+		chirp    Chirp
+		want     bool
+	}{
+		{"public to anonymous", 0, Chirp{AuthorID: 1}, true},
+		{"stored before visibility", 3, Chirp{AuthorID: 1, Visibility: ""}, true},
+		{"followers to follower", 2, Chirp{AuthorID: 1, Visibility: VisibilityFollowers}, true},
+		{"followers to other user", 3, Chirp{AuthorID: 1, Visibility: VisibilityFollowers}, false},
+		{"followers to anonymous", 0, Chirp{AuthorID: 1, Visibility: VisibilityFollowers}, false},
+		{"followers to author", 1, Chirp{AuthorID: 1, Visibility: VisibilityFollowers}, true},
+		{"private to follower", 2, Chirp{AuthorID: 1, Visibility: VisibilityPrivate}, false},
+		{"private to author", 1, Chirp{AuthorID: 1, Visibility: VisibilityPrivate}, true},
+		{"expired to author", 1, Chirp{AuthorID: 1, ExpiresAt: &past}, false},
+		{"not yet expired", 3, Chirp{AuthorID: 1, ExpiresAt: &future}, true},
+		{"hidden", 3, Chirp{AuthorID: 1, Hidden: true}, false},
+		{"hidden to author", 1, Chirp{AuthorID: 1, Hidden: true}, true},
+		{"held for review", 3, Chirp{AuthorID: 1, HeldForReview: true}, false},
+		{"held for review to author", 1, Chirp{AuthorID: 1, HeldForReview: true}, true},
+		{"shadowbanned author", 3, Chirp{AuthorID: 4}, false},
+		{"shadowbanned author to author", 4, Chirp{AuthorID: 4}, true},
+		{"suspended author hiding chirps", 3, Chirp{AuthorID: 5}, false},
+		{"suspended author keeping chirps", 3, Chirp{AuthorID: 6}, true},
+		{"suspension ended", 3, Chirp{AuthorID: 7}, true},
+	}
+	for _, tt := range tests {
+		if got := dbs.CanView(tt.viewerid, tt.chirp, now); got != tt.want {
+			t.Errorf("%s: want %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
@@ -45,8 +45,11 @@ func (cfg *apiConfig) PostPolkaWebhooks(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	user.IsChirpyRed = true
-	cfg.DB.UpdateUser(user.ID, user)
+	_, err = cfg.DB.UpgradeUser(user.ID, Actor{Type: ActorWebhook, RequestID: requestID(req)})
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
 
 	respondWithJSON(w, 200, "User upgraded")
 }